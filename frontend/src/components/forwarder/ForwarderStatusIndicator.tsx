@@ -1,8 +1,14 @@
-import { SignalIcon, SignalSlashIcon } from "@heroicons/vue/20/solid";
+import {
+  ExclamationTriangleIcon,
+  SignalIcon,
+  SignalSlashIcon,
+} from "@heroicons/vue/20/solid";
 
-import { defineComponent, ref, onMounted, onUnmounted } from "vue";
+import { defineComponent, ref, computed, onMounted, onUnmounted } from "vue";
+import { storeToRefs } from "pinia";
 
 import { ForwarderStarted } from "~/wails/go/phantom/Application";
+import { useRuntimeStore } from "~/store/runtime";
 import broker from "~/events";
 
 export default defineComponent({
@@ -12,6 +18,15 @@ export default defineComponent({
   },
   setup(props) {
     const started = ref(false);
+    const { BatchResults } = storeToRefs(useRuntimeStore());
+    // set when the forwarder failed in the last batch start and is still stopped
+    const failure = computed(() => {
+      const r = BatchResults.value[props.listen];
+      if (started.value || !r || r.outcome === "started") {
+        return undefined;
+      }
+      return r;
+    });
 
     function getEventHandler(set: boolean): (l: string) => void {
       return (l: string) => {
@@ -43,8 +58,18 @@ export default defineComponent({
     });
 
     return () => {
-      const Icon = started.value ? SignalIcon : SignalSlashIcon;
       const { ...otherProps } = props;
+      if (failure.value) {
+        const r = failure.value;
+        return (
+          <ExclamationTriangleIcon
+            class="inline-block text-yellow-500 dark:text-yellow-400"
+            title={r.error ? `${r.outcome}: ${r.error}` : r.outcome}
+            {...otherProps}
+          />
+        );
+      }
+      const Icon = started.value ? SignalIcon : SignalSlashIcon;
       return (
         <Icon
           class={[
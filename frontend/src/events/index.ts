@@ -1,12 +1,20 @@
 import mitt from "mitt";
 
+import type { phantom } from "~/wails/go/models";
+
 export type Events = {
   "forwarder:Started": string;
   "forwarder:Stopped": string;
+  "forwarder:IdleStopped": string;
 
-  "forwarders:Started": void;
+  "forwarders:Started": phantom.ForwarderResult[] | undefined;
   "forwarders:Starting": void;
-  "forwarders:Stopped": void;
+  "forwarders:Stopped": phantom.ForwarderResult[] | undefined;
+  "forwarders:Partial": phantom.ForwarderResult[];
+
+  "mirror:Updated": phantom.MirrorEntry[];
+
+  "tunnel:Expired": string;
 
   "specter:Connected": void;
   "specter:Connecting": void;
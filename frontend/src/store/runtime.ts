@@ -11,6 +11,8 @@ import {
   AllForwardersStarted,
   Connected,
 } from "~/wails/go/phantom/Application";
+import type { phantom } from "~/wails/go/models";
+import { useAlertStore } from "~/store/alert";
 import { useLoadingStore } from "~/store/loading";
 import broker from "~/events";
 
@@ -19,6 +21,9 @@ export const useRuntimeStore = defineStore("runtime", () => {
   const ClientConnected = ref<boolean>(false);
   const ForwardersStarting = ref<boolean>(false);
   const ForwardersStarted = ref<boolean>(false);
+  const ForwardersPartial = ref<boolean>(false);
+  // outcome of the last batch start, keyed by listen address
+  const BatchResults = ref<Record<string, phantom.ForwarderResult>>({});
   const environment = ref<EnvironmentInfo>();
 
   Promise.all([Environment(), Connected()]).then(([env, c]) => {
@@ -56,19 +61,33 @@ export const useRuntimeStore = defineStore("runtime", () => {
     setLoading(true);
   });
 
-  EventsOn("forwarders:Started", () => {
+  EventsOn("forwarders:Started", (results?: phantom.ForwarderResult[]) => {
     const { setLoading } = useLoadingStore();
     ForwardersStarted.value = true;
+    ForwardersPartial.value = false;
     ForwardersStarting.value = false;
-    broker.emit("forwarders:Started");
+    setBatchResults(results);
+    broker.emit("forwarders:Started", results);
     setLoading(false);
   });
 
-  EventsOn("forwarders:Stopped", () => {
+  EventsOn("forwarders:Partial", (results: phantom.ForwarderResult[]) => {
     const { setLoading } = useLoadingStore();
     ForwardersStarted.value = false;
+    ForwardersPartial.value = true;
     ForwardersStarting.value = false;
-    broker.emit("forwarders:Stopped");
+    setBatchResults(results);
+    broker.emit("forwarders:Partial", results);
+    setLoading(false);
+  });
+
+  EventsOn("forwarders:Stopped", (results?: phantom.ForwarderResult[]) => {
+    const { setLoading } = useLoadingStore();
+    ForwardersStarted.value = false;
+    ForwardersPartial.value = false;
+    ForwardersStarting.value = false;
+    setBatchResults(results);
+    broker.emit("forwarders:Stopped", results);
     setLoading(false);
   });
 
@@ -80,9 +99,36 @@ export const useRuntimeStore = defineStore("runtime", () => {
     broker.emit("forwarder:Stopped", l);
   });
 
+  EventsOn("mirror:Updated", (entries: phantom.MirrorEntry[]) => {
+    broker.emit("mirror:Updated", entries);
+  });
+
+  EventsOn("forwarder:IdleStopped", (l: string, minutes: number) => {
+    const { showAlert } = useAlertStore();
+    showAlert(
+      "info",
+      `Forwarder ${l} was stopped after ${minutes} minutes without connections`
+    );
+    broker.emit("forwarder:IdleStopped", l);
+  });
+
+  EventsOn("tunnel:Expired", (hostname: string) => {
+    const { showAlert } = useAlertStore();
+    showAlert("info", `Tunnel ${hostname} was unpublished after its TTL`);
+    broker.emit("tunnel:Expired", hostname);
+  });
+
   // notify the backend to hydrate states if necessary
   EventsEmit("broker:Ready");
 
+  function setBatchResults(results?: phantom.ForwarderResult[]) {
+    const byListen: Record<string, phantom.ForwarderResult> = {};
+    for (const r of results ?? []) {
+      byListen[r.listen] = r;
+    }
+    BatchResults.value = byListen;
+  }
+
   async function reloadForwardersStatus() {
     ForwardersStarted.value = await AllForwardersStarted();
   }
@@ -90,6 +136,8 @@ export const useRuntimeStore = defineStore("runtime", () => {
   return {
     ForwardersStarted,
     ForwardersStarting,
+    ForwardersPartial,
+    BatchResults,
     ClientConnecting,
     ClientConnected,
     environment,
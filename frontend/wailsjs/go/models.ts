@@ -57,9 +57,33 @@ export namespace client {
 
 export namespace phantom {
 	
+	export class DNSConfig {
+	    enabled: boolean;
+	    listen?: string;
+	    zone?: string;
+	    upstream?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new DNSConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.enabled = source["enabled"];
+	        this.listen = source["listen"];
+	        this.zone = source["zone"];
+	        this.upstream = source["upstream"];
+	    }
+	}
 	export class ForwarderNode {
 	    label: string;
+	    listen: string;
+	    address: string;
 	    via: string;
+	    active: number;
+	    rejected: number;
+	    limited: number;
+	    status: string;
 	
 	    static createFrom(source: any = {}) {
 	        return new ForwarderNode(source);
@@ -68,15 +92,235 @@ export namespace phantom {
 	    constructor(source: any = {}) {
 	        if ('string' === typeof source) source = JSON.parse(source);
 	        this.label = source["label"];
+	        this.listen = source["listen"];
+	        this.address = source["address"];
+	        this.via = source["via"];
+	        this.active = source["active"];
+	        this.rejected = source["rejected"];
+	        this.limited = source["limited"];
+	        this.status = source["status"];
+	    }
+	}
+	export class ForwarderResult {
+	    label: string;
+	    listen: string;
+	    outcome: string;
+	    error?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new ForwarderResult(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.label = source["label"];
+	        this.listen = source["listen"];
+	        this.outcome = source["outcome"];
+	        this.error = source["error"];
+	    }
+	}
+	export class RouteStatus {
+	    hostname: string;
+	    status: string;
+	    lastError?: string;
+	    transport?: string;
+	    via: string;
+	    rtt: number;
+	    active: number;
+	    served: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new RouteStatus(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.hostname = source["hostname"];
+	        this.status = source["status"];
+	        this.lastError = source["lastError"];
+	        this.transport = source["transport"];
 	        this.via = source["via"];
+	        this.rtt = source["rtt"];
+	        this.active = source["active"];
+	        this.served = source["served"];
+	    }
+	}
+	export class ForwarderStatus {
+	    listen: string;
+	    address: string;
+	    status: string;
+	    lastError?: string;
+	    transport?: string;
+	    // Go type: time
+	    lastCheck: any;
+	    routes: RouteStatus[];
+	
+	    static createFrom(source: any = {}) {
+	        return new ForwarderStatus(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.listen = source["listen"];
+	        this.address = source["address"];
+	        this.status = source["status"];
+	        this.lastError = source["lastError"];
+	        this.transport = source["transport"];
+	        this.lastCheck = this.convertValues(source["lastCheck"], null);
+	        this.routes = this.convertValues(source["routes"], RouteStatus);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class TemplateConfig {
+	    path: string;
+	    template: string;
+	    cleanup?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new TemplateConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.path = source["path"];
+	        this.template = source["template"];
+	        this.cleanup = source["cleanup"];
+	    }
+	}
+	export class GroupConfig {
+	    name: string;
+	    autostart: boolean;
+	    templates?: TemplateConfig[];
+	
+	    static createFrom(source: any = {}) {
+	        return new GroupConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.autostart = source["autostart"];
+	        this.templates = this.convertValues(source["templates"], TemplateConfig);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class GroupStatus {
+	    name: string;
+	    autostart: boolean;
+	    state: string;
+	    total: number;
+	    running: number;
+	    healthy: number;
+	    degraded: number;
+	    failed: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new GroupStatus(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.autostart = source["autostart"];
+	        this.state = source["state"];
+	        this.total = source["total"];
+	        this.running = source["running"];
+	        this.healthy = source["healthy"];
+	        this.degraded = source["degraded"];
+	        this.failed = source["failed"];
+	    }
+	}
+	export class HTTPRoute {
+	    host: string;
+	    hostname: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new HTTPRoute(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.host = source["host"];
+	        this.hostname = source["hostname"];
+	    }
+	}
+	export class HostsFileConfig {
+	    enabled: boolean;
+	    path?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new HostsFileConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.enabled = source["enabled"];
+	        this.path = source["path"];
 	    }
 	}
 	export class Listener {
 	    label: string;
 	    listen: string;
+	    mode?: string;
+	    httpRoutes?: HTTPRoute[];
 	    hostname: string;
+	    hostnames?: string[];
+	    strategy?: string;
 	    insecure: boolean;
 	    tcp: boolean;
+	    transport?: string;
+	    allow?: string[];
+	    deny?: string[];
+	    maxConnections?: number;
+	    connectionsPerSecond?: number;
+	    queueExcess?: boolean;
+	    uploadLimit?: number;
+	    downloadLimit?: number;
+	    lazy?: boolean;
+	    lazyIdleTimeout?: number;
+	    persistPort?: boolean;
+	    group?: string;
+	    autostart?: boolean;
+	    terminateTLS?: boolean;
+	    tlsNames?: string[];
+	    hostAliases?: string[];
+	    mirrored?: boolean;
+	    schedule?: string;
+	    idleTimeout?: number;
 	
 	    static createFrom(source: any = {}) {
 	        return new Listener(source);
@@ -86,9 +330,86 @@ export namespace phantom {
 	        if ('string' === typeof source) source = JSON.parse(source);
 	        this.label = source["label"];
 	        this.listen = source["listen"];
+	        this.mode = source["mode"];
+	        this.httpRoutes = this.convertValues(source["httpRoutes"], HTTPRoute);
 	        this.hostname = source["hostname"];
+	        this.hostnames = source["hostnames"];
+	        this.strategy = source["strategy"];
 	        this.insecure = source["insecure"];
 	        this.tcp = source["tcp"];
+	        this.transport = source["transport"];
+	        this.allow = source["allow"];
+	        this.deny = source["deny"];
+	        this.maxConnections = source["maxConnections"];
+	        this.connectionsPerSecond = source["connectionsPerSecond"];
+	        this.queueExcess = source["queueExcess"];
+	        this.uploadLimit = source["uploadLimit"];
+	        this.downloadLimit = source["downloadLimit"];
+	        this.lazy = source["lazy"];
+	        this.lazyIdleTimeout = source["lazyIdleTimeout"];
+	        this.persistPort = source["persistPort"];
+	        this.group = source["group"];
+	        this.autostart = source["autostart"];
+	        this.terminateTLS = source["terminateTLS"];
+	        this.tlsNames = source["tlsNames"];
+	        this.hostAliases = source["hostAliases"];
+	        this.mirrored = source["mirrored"];
+	        this.schedule = source["schedule"];
+	        this.idleTimeout = source["idleTimeout"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class MirrorConfig {
+	    enabled: boolean;
+	    pattern?: string;
+	    host?: string;
+	    group?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new MirrorConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.enabled = source["enabled"];
+	        this.pattern = source["pattern"];
+	        this.host = source["host"];
+	        this.group = source["group"];
+	    }
+	}
+	export class MirrorEntry {
+	    hostname: string;
+	    listen: string;
+	    address?: string;
+	    running: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new MirrorEntry(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.hostname = source["hostname"];
+	        this.listen = source["listen"];
+	        this.address = source["address"];
+	        this.running = source["running"];
 	    }
 	}
 	export class Paths {
@@ -107,11 +428,57 @@ export namespace phantom {
 	        this.log = source["log"];
 	    }
 	}
+	export class TunnelMetadata {
+	    hostname: string;
+	    schedule?: string;
+	    ttl?: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new TunnelMetadata(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.hostname = source["hostname"];
+	        this.schedule = source["schedule"];
+	        this.ttl = source["ttl"];
+	    }
+	}
+	export class ProxyConfig {
+	    mode: string;
+	    url?: string;
+	    username?: string;
+	    password?: string;
+	    hasPassword?: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new ProxyConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.mode = source["mode"];
+	        this.url = source["url"];
+	        this.username = source["username"];
+	        this.password = source["password"];
+	        this.hasPassword = source["hasPassword"];
+	    }
+	}
 	export class PhantomConfig {
 	    listeners: Listener[];
 	    listenOnStart: boolean;
 	    specterInsecure: boolean;
 	    connectOnStart: boolean;
+	    restrictPublicListen: boolean;
+	    proxy: ProxyConfig;
+	    groups?: GroupConfig[];
+	    restoreForwarders: boolean;
+	    lastRunning?: string[];
+	    drainTimeout: number;
+	    dns: DNSConfig;
+	    hostsFile: HostsFileConfig;
+	    mirror: MirrorConfig;
+	    tunnelMeta?: TunnelMetadata[];
 	
 	    static createFrom(source: any = {}) {
 	        return new PhantomConfig(source);
@@ -123,6 +490,16 @@ export namespace phantom {
 	        this.listenOnStart = source["listenOnStart"];
 	        this.specterInsecure = source["specterInsecure"];
 	        this.connectOnStart = source["connectOnStart"];
+	        this.restrictPublicListen = source["restrictPublicListen"];
+	        this.proxy = this.convertValues(source["proxy"], ProxyConfig);
+	        this.groups = this.convertValues(source["groups"], GroupConfig);
+	        this.restoreForwarders = source["restoreForwarders"];
+	        this.lastRunning = source["lastRunning"];
+	        this.drainTimeout = source["drainTimeout"];
+	        this.dns = this.convertValues(source["dns"], DNSConfig);
+	        this.hostsFile = this.convertValues(source["hostsFile"], HostsFileConfig);
+	        this.mirror = this.convertValues(source["mirror"], MirrorConfig);
+	        this.tunnelMeta = this.convertValues(source["tunnelMeta"], TunnelMetadata);
 	    }
 	
 		convertValues(a: any, classs: any, asMap: boolean = false): any {
@@ -143,6 +520,63 @@ export namespace phantom {
 		    return a;
 		}
 	}
+	export class PreflightStep {
+	    name: string;
+	    success: boolean;
+	    detail?: string;
+	    error?: string;
+	    duration: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new PreflightStep(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.success = source["success"];
+	        this.detail = source["detail"];
+	        this.error = source["error"];
+	        this.duration = source["duration"];
+	    }
+	}
+	export class PreflightReport {
+	    hostname: string;
+	    success: boolean;
+	    steps: PreflightStep[];
+	
+	    static createFrom(source: any = {}) {
+	        return new PreflightReport(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.hostname = source["hostname"];
+	        this.success = source["success"];
+	        this.steps = this.convertValues(source["steps"], PreflightStep);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	
+	
+	
 	export class Target {
 	    protocol: string;
 	    destination: string;
@@ -159,6 +593,8 @@ export namespace phantom {
 	        this.error = source["error"];
 	    }
 	}
+	
+	
 	export class TunnelNode {
 	    id?: number;
 	    address?: string;
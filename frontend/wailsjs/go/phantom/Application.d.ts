@@ -7,20 +7,40 @@ export function AddForwarder(arg1:phantom.Listener):Promise<void>;
 
 export function AllForwardersStarted():Promise<boolean>;
 
+export function CheckListenAddress(arg1:string):Promise<void>;
+
 export function Connected():Promise<boolean>;
 
+export function ExportDevCA():Promise<string>;
+
 export function ForwarderStarted(arg1:string):Promise<boolean>;
 
 export function GetConnectedForwarderNodes():Promise<Array<phantom.ForwarderNode>>;
 
 export function GetConnectedTunnelNodes():Promise<Array<phantom.TunnelNode>>;
 
+export function GetDNSSetup():Promise<string>;
+
+export function GetDevCAPath():Promise<string>;
+
+export function GetExpiredTunnels():Promise<Array<string>>;
+
+export function GetForwarderStatus(arg1:string):Promise<phantom.ForwarderStatus>;
+
+export function GetGroupStatus(arg1:string):Promise<phantom.GroupStatus>;
+
+export function GetGroups():Promise<Array<phantom.GroupStatus>>;
+
+export function GetMirrorMapping():Promise<Array<phantom.MirrorEntry>>;
+
 export function GetPhantomConfig():Promise<phantom.PhantomConfig>;
 
 export function GetRegisteredHostnames():Promise<Array<string>>;
 
 export function GetSpecterConfig():Promise<client.Config>;
 
+export function PublishTunnel(arg1:number):Promise<void>;
+
 export function RebuildTunnels(arg1:Array<client.Tunnel>):Promise<void>;
 
 export function ReleaseTunnel(arg1:number):Promise<void>;
@@ -29,24 +49,48 @@ export function RemoveForwarder(arg1:number):Promise<void>;
 
 export function RunningForwarders():Promise<number>;
 
-export function StartAllForwarders():Promise<void>;
+export function StartAllForwarders():Promise<Array<phantom.ForwarderResult>>;
+
+export function StartAllForwardersTransactional():Promise<Array<phantom.ForwarderResult>>;
 
 export function StartClient():Promise<void>;
 
 export function StartForwarder(arg1:number):Promise<void>;
 
+export function StartGroup(arg1:string):Promise<Array<phantom.ForwarderResult>>;
+
 export function StopAllForwarders():Promise<void>;
 
 export function StopClient():Promise<void>;
 
 export function StopForwarder(arg1:number):Promise<void>;
 
+export function StopGroup(arg1:string):Promise<void>;
+
+export function SuggestListenAddress(arg1:string):Promise<string>;
+
+export function SyncMirror():Promise<void>;
+
 export function Synchronize():Promise<void>;
 
+export function TestForwarder(arg1:phantom.Listener):Promise<phantom.PreflightReport>;
+
 export function UnpublishTunnel(arg1:number):Promise<void>;
 
 export function UpdateApex(arg1:string):Promise<void>;
 
 export function UpdateForwaderLabel(arg1:number,arg2:string):Promise<void>;
 
+export function UpdateForwarderGroup(arg1:number,arg2:string):Promise<void>;
+
+export function UpdateForwarderIdleTimeout(arg1:number,arg2:number):Promise<void>;
+
+export function UpdateForwarderSchedule(arg1:number,arg2:string):Promise<void>;
+
+export function UpdateGroup(arg1:phantom.GroupConfig):Promise<void>;
+
 export function UpdatePhantomConfig(arg1:phantom.PhantomConfig):Promise<void>;
+
+export function UpdateTunnelSchedule(arg1:string,arg2:string):Promise<void>;
+
+export function UpdateTunnelTTL(arg1:string,arg2:number):Promise<void>;
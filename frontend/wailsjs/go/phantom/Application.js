@@ -10,10 +10,18 @@ export function AllForwardersStarted() {
   return window['go']['phantom']['Application']['AllForwardersStarted']();
 }
 
+export function CheckListenAddress(arg1) {
+  return window['go']['phantom']['Application']['CheckListenAddress'](arg1);
+}
+
 export function Connected() {
   return window['go']['phantom']['Application']['Connected']();
 }
 
+export function ExportDevCA() {
+  return window['go']['phantom']['Application']['ExportDevCA']();
+}
+
 export function ForwarderStarted(arg1) {
   return window['go']['phantom']['Application']['ForwarderStarted'](arg1);
 }
@@ -26,6 +34,34 @@ export function GetConnectedTunnelNodes() {
   return window['go']['phantom']['Application']['GetConnectedTunnelNodes']();
 }
 
+export function GetDNSSetup() {
+  return window['go']['phantom']['Application']['GetDNSSetup']();
+}
+
+export function GetDevCAPath() {
+  return window['go']['phantom']['Application']['GetDevCAPath']();
+}
+
+export function GetExpiredTunnels() {
+  return window['go']['phantom']['Application']['GetExpiredTunnels']();
+}
+
+export function GetForwarderStatus(arg1) {
+  return window['go']['phantom']['Application']['GetForwarderStatus'](arg1);
+}
+
+export function GetGroupStatus(arg1) {
+  return window['go']['phantom']['Application']['GetGroupStatus'](arg1);
+}
+
+export function GetGroups() {
+  return window['go']['phantom']['Application']['GetGroups']();
+}
+
+export function GetMirrorMapping() {
+  return window['go']['phantom']['Application']['GetMirrorMapping']();
+}
+
 export function GetPhantomConfig() {
   return window['go']['phantom']['Application']['GetPhantomConfig']();
 }
@@ -38,6 +74,10 @@ export function GetSpecterConfig() {
   return window['go']['phantom']['Application']['GetSpecterConfig']();
 }
 
+export function PublishTunnel(arg1) {
+  return window['go']['phantom']['Application']['PublishTunnel'](arg1);
+}
+
 export function RebuildTunnels(arg1) {
   return window['go']['phantom']['Application']['RebuildTunnels'](arg1);
 }
@@ -58,6 +98,10 @@ export function StartAllForwarders() {
   return window['go']['phantom']['Application']['StartAllForwarders']();
 }
 
+export function StartAllForwardersTransactional() {
+  return window['go']['phantom']['Application']['StartAllForwardersTransactional']();
+}
+
 export function StartClient() {
   return window['go']['phantom']['Application']['StartClient']();
 }
@@ -66,6 +110,10 @@ export function StartForwarder(arg1) {
   return window['go']['phantom']['Application']['StartForwarder'](arg1);
 }
 
+export function StartGroup(arg1) {
+  return window['go']['phantom']['Application']['StartGroup'](arg1);
+}
+
 export function StopAllForwarders() {
   return window['go']['phantom']['Application']['StopAllForwarders']();
 }
@@ -78,10 +126,26 @@ export function StopForwarder(arg1) {
   return window['go']['phantom']['Application']['StopForwarder'](arg1);
 }
 
+export function StopGroup(arg1) {
+  return window['go']['phantom']['Application']['StopGroup'](arg1);
+}
+
+export function SuggestListenAddress(arg1) {
+  return window['go']['phantom']['Application']['SuggestListenAddress'](arg1);
+}
+
+export function SyncMirror() {
+  return window['go']['phantom']['Application']['SyncMirror']();
+}
+
 export function Synchronize() {
   return window['go']['phantom']['Application']['Synchronize']();
 }
 
+export function TestForwarder(arg1) {
+  return window['go']['phantom']['Application']['TestForwarder'](arg1);
+}
+
 export function UnpublishTunnel(arg1) {
   return window['go']['phantom']['Application']['UnpublishTunnel'](arg1);
 }
@@ -94,6 +158,30 @@ export function UpdateForwaderLabel(arg1, arg2) {
   return window['go']['phantom']['Application']['UpdateForwaderLabel'](arg1, arg2);
 }
 
+export function UpdateForwarderGroup(arg1, arg2) {
+  return window['go']['phantom']['Application']['UpdateForwarderGroup'](arg1, arg2);
+}
+
+export function UpdateForwarderIdleTimeout(arg1, arg2) {
+  return window['go']['phantom']['Application']['UpdateForwarderIdleTimeout'](arg1, arg2);
+}
+
+export function UpdateForwarderSchedule(arg1, arg2) {
+  return window['go']['phantom']['Application']['UpdateForwarderSchedule'](arg1, arg2);
+}
+
+export function UpdateGroup(arg1) {
+  return window['go']['phantom']['Application']['UpdateGroup'](arg1);
+}
+
 export function UpdatePhantomConfig(arg1) {
   return window['go']['phantom']['Application']['UpdatePhantomConfig'](arg1);
 }
+
+export function UpdateTunnelSchedule(arg1, arg2) {
+  return window['go']['phantom']['Application']['UpdateTunnelSchedule'](arg1, arg2);
+}
+
+export function UpdateTunnelTTL(arg1, arg2) {
+  return window['go']['phantom']['Application']['UpdateTunnelTTL'](arg1, arg2);
+}
@@ -8,6 +8,7 @@ require (
 	github.com/zhangyunhao116/skipmap v0.10.1
 	go.uber.org/zap v1.24.0
 	golang.design/x/clipboard v0.7.0
+	golang.org/x/net v0.8.0
 	kon.nect.sh/specter v0.0.0-20230314040350-677130ce31ae
 )
 
@@ -55,7 +56,6 @@ require (
 	golang.org/x/image v0.6.0 // indirect
 	golang.org/x/mobile v0.0.0-20230301163155-e0f57694e12c // indirect
 	golang.org/x/mod v0.9.0 // indirect
-	golang.org/x/net v0.8.0 // indirect
 	golang.org/x/sys v0.6.0 // indirect
 	golang.org/x/text v0.8.0 // indirect
 	golang.org/x/tools v0.7.0 // indirect
@@ -0,0 +1,261 @@
+// Package devca manages a local development certificate authority, and issues
+// short-lived leaf certificates from it for terminating TLS on loopback listeners.
+//
+// The CA certificate and key are stored as PEM files in a directory of your
+// choosing. Install the CA certificate into the system or browser trust store
+// once, and every leaf certificate issued afterwards will be trusted.
+package devca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	certFile = "ca.pem"
+	keyFile  = "ca-key.pem"
+)
+
+var (
+	// CAValidity is how long a newly generated CA certificate is valid for.
+	CAValidity = time.Hour * 24 * 365 * 10
+	// LeafValidity is how long an issued leaf certificate is valid for.
+	LeafValidity = time.Hour * 24 * 30
+	// RenewBefore is how long before expiry a leaf certificate is reissued.
+	RenewBefore = time.Hour * 24 * 7
+)
+
+// Authority is a development CA backed by files in a directory.
+type Authority struct {
+	dir     string
+	cert    *x509.Certificate
+	key     crypto.Signer
+	certPEM []byte
+
+	mu     sync.Mutex
+	leaves map[string]*tls.Certificate
+}
+
+// Load reads the CA from dir, generating a new one if it does not exist yet.
+func Load(dir string) (*Authority, error) {
+	a := &Authority{
+		dir:    dir,
+		leaves: make(map[string]*tls.Certificate),
+	}
+
+	certPEM, err := os.ReadFile(filepath.Join(dir, certFile))
+	if errors.Is(err, os.ErrNotExist) {
+		if err := a.generate(); err != nil {
+			return nil, fmt.Errorf("generating CA: %w", err)
+		}
+		return a, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading CA certificate: %w", err)
+	}
+
+	keyPEM, err := os.ReadFile(filepath.Join(dir, keyFile))
+	if err != nil {
+		return nil, fmt.Errorf("reading CA key: %w", err)
+	}
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("parsing CA: %w", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("parsing CA certificate: %w", err)
+	}
+	signer, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("CA key cannot be used for signing")
+	}
+
+	a.cert = cert
+	a.key = signer
+	a.certPEM = certPEM
+
+	return a, nil
+}
+
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func (a *Authority) generate() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"Phantom Development CA"},
+			CommonName:   "Phantom Development CA " + now.Format("2006-01-02"),
+		},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(CAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+	if err := os.MkdirAll(a.dir, 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(a.dir, keyFile), keyPEM, 0600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(a.dir, certFile), certPEM, 0644); err != nil {
+		return err
+	}
+
+	a.cert = cert
+	a.key = key
+	a.certPEM = certPEM
+
+	return nil
+}
+
+// CertPEM returns the PEM encoded CA certificate, for installing into a trust store.
+func (a *Authority) CertPEM() []byte {
+	return a.certPEM
+}
+
+// CertPath returns the path of the CA certificate file.
+func (a *Authority) CertPath() string {
+	return filepath.Join(a.dir, certFile)
+}
+
+func leafKey(names []string) string {
+	sorted := make([]string, len(names))
+	copy(sorted, names)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+// Issue returns a leaf certificate valid for names, which may contain both
+// hostnames and IP addresses. Certificates are cached, and reissued when they
+// are close to expiry.
+func (a *Authority) Issue(names []string) (*tls.Certificate, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no names to issue certificate for")
+	}
+
+	key := leafKey(names)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if leaf, ok := a.leaves[key]; ok && time.Until(leaf.Leaf.NotAfter) > RenewBefore {
+		return leaf, nil
+	}
+
+	leaf, err := a.issue(names)
+	if err != nil {
+		return nil, err
+	}
+	a.leaves[key] = leaf
+
+	return leaf, nil
+}
+
+func (a *Authority) issue(names []string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"Phantom Development Certificate"},
+			CommonName:   names[0],
+		},
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(LeafValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+	if template.NotAfter.After(a.cert.NotAfter) {
+		template.NotAfter = a.cert.NotAfter
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, key.Public(), a.key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der, a.cert.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// ServerConfig returns a TLS config that presents a leaf certificate for names,
+// rotating it automatically as it approaches expiry.
+func (a *Authority) ServerConfig(names []string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return a.Issue(names)
+		},
+	}
+}
@@ -0,0 +1,206 @@
+package devca
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func loadTestAuthority(t *testing.T) (*Authority, string) {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "ca")
+	a, err := Load(dir)
+	if err != nil {
+		t.Fatalf("unexpected error loading CA: %v", err)
+	}
+	return a, dir
+}
+
+func rootPool(a *Authority) *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(a.CertPEM())
+	return pool
+}
+
+func TestLoad(t *testing.T) {
+	a, dir := loadTestAuthority(t)
+
+	if !a.cert.IsCA {
+		t.Fatal("expected a CA certificate")
+	}
+	if a.CertPath() != filepath.Join(dir, certFile) {
+		t.Fatalf("unexpected cert path %s", a.CertPath())
+	}
+
+	info, err := os.Stat(filepath.Join(dir, keyFile))
+	if err != nil {
+		t.Fatalf("expected the CA key on disk: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Fatalf("expected the CA key to be private, got %o", perm)
+	}
+
+	reloaded, err := Load(dir)
+	if err != nil {
+		t.Fatalf("unexpected error reloading CA: %v", err)
+	}
+	if !bytes.Equal(reloaded.cert.Raw, a.cert.Raw) {
+		t.Fatal("expected reloading to return the same CA")
+	}
+	if !bytes.Equal(reloaded.CertPEM(), a.CertPEM()) {
+		t.Fatal("expected reloading to return the same CA PEM")
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	cases := []struct {
+		name  string
+		setup func(dir string) error
+	}{
+		{
+			name: "corrupt certificate",
+			setup: func(dir string) error {
+				if err := os.WriteFile(filepath.Join(dir, certFile), []byte("not a certificate"), 0644); err != nil {
+					return err
+				}
+				return os.WriteFile(filepath.Join(dir, keyFile), []byte("not a key"), 0600)
+			},
+		},
+		{
+			name: "missing key",
+			setup: func(dir string) error {
+				return os.Remove(filepath.Join(dir, keyFile))
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, dir := loadTestAuthority(t)
+			if err := tc.setup(dir); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Load(dir); err == nil {
+				t.Fatal("expected loading to fail")
+			}
+		})
+	}
+}
+
+func TestIssue(t *testing.T) {
+	a, _ := loadTestAuthority(t)
+
+	cases := []struct {
+		name  string
+		names []string
+		dns   []string
+		ips   []string
+	}{
+		{
+			name:  "hostname",
+			names: []string{"localhost"},
+			dns:   []string{"localhost"},
+		},
+		{
+			name:  "hostnames and addresses",
+			names: []string{"app.test", "127.0.0.1", "::1", "localhost"},
+			dns:   []string{"app.test", "localhost"},
+			ips:   []string{"127.0.0.1", "::1"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cert, err := a.Issue(tc.names)
+			if err != nil {
+				t.Fatalf("unexpected error issuing: %v", err)
+			}
+			leaf := cert.Leaf
+
+			if len(leaf.DNSNames) != len(tc.dns) {
+				t.Fatalf("expected DNS names %v, got %v", tc.dns, leaf.DNSNames)
+			}
+			if len(leaf.IPAddresses) != len(tc.ips) {
+				t.Fatalf("expected addresses %v, got %v", tc.ips, leaf.IPAddresses)
+			}
+			if leaf.NotAfter.After(a.cert.NotAfter) {
+				t.Fatal("expected the leaf to expire before the CA")
+			}
+
+			for _, name := range append(tc.dns, tc.ips...) {
+				_, err := leaf.Verify(x509.VerifyOptions{
+					DNSName: name,
+					Roots:   rootPool(a),
+				})
+				if err != nil {
+					t.Fatalf("expected the leaf to verify for %s: %v", name, err)
+				}
+			}
+		})
+	}
+
+	if _, err := a.Issue(nil); err == nil {
+		t.Fatal("expected issuing without names to fail")
+	}
+}
+
+func TestIssueCache(t *testing.T) {
+	a, _ := loadTestAuthority(t)
+
+	first, err := a.Issue([]string{"localhost", "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := a.Issue([]string{"127.0.0.1", "localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Fatal("expected the same names in any order to reuse the cached leaf")
+	}
+
+	other, err := a.Issue([]string{"localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == first {
+		t.Fatal("expected different names to issue a different leaf")
+	}
+
+	renew := RenewBefore
+	RenewBefore = LeafValidity + time.Hour
+	t.Cleanup(func() { RenewBefore = renew })
+
+	renewed, err := a.Issue([]string{"localhost", "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed == first {
+		t.Fatal("expected a leaf close to expiry to be reissued")
+	}
+}
+
+func TestServerConfig(t *testing.T) {
+	a, _ := loadTestAuthority(t)
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	server := tls.Server(serverConn, a.ServerConfig([]string{"localhost"}))
+	go server.Handshake()
+
+	client := tls.Client(clientConn, &tls.Config{
+		ServerName: "localhost",
+		RootCAs:    rootPool(a),
+	})
+	client.SetDeadline(time.Now().Add(time.Second * 5))
+	if err := client.Handshake(); err != nil {
+		t.Fatalf("expected the handshake to verify against the CA: %v", err)
+	}
+}
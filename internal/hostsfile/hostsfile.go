@@ -0,0 +1,146 @@
+// Package hostsfile maintains a clearly delimited block of entries in a hosts
+// file, leaving the rest of the file untouched.
+//
+// The block is rewritten as a whole on every update, and the file is replaced
+// atomically where the platform allows it, so readers never observe a partial
+// write. Updating with no entries removes the block altogether.
+package hostsfile
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Entry maps names to an IP address.
+type Entry struct {
+	IP    string
+	Names []string
+}
+
+// DefaultPath returns the location of the system hosts file.
+func DefaultPath() string {
+	if runtime.GOOS == "windows" {
+		root := os.Getenv("SystemRoot")
+		if root == "" {
+			root = `C:\Windows`
+		}
+		return filepath.Join(root, "System32", "drivers", "etc", "hosts")
+	}
+	return "/etc/hosts"
+}
+
+func markers(tag string) (begin, end string) {
+	return fmt.Sprintf("# BEGIN %s managed block, do not edit", tag), fmt.Sprintf("# END %s managed block", tag)
+}
+
+// Update replaces the block identified by tag in the hosts file at path with entries.
+// The file is created if it does not exist.
+func Update(path, tag string, entries []Entry) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	eol := "\n"
+	if bytes.Contains(data, []byte("\r\n")) {
+		eol = "\r\n"
+	}
+
+	out := []byte(render(strip(string(data), tag), tag, entries, eol))
+	if bytes.Equal(data, out) {
+		return nil
+	}
+
+	return writeAtomic(path, out)
+}
+
+// Remove deletes the block identified by tag from the hosts file at path.
+func Remove(path, tag string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	return Update(path, tag, nil)
+}
+
+// strip returns the content without the block. An unterminated block extends to the end of the file.
+func strip(content, tag string) string {
+	begin, end := markers(tag)
+
+	var (
+		sb      strings.Builder
+		inBlock bool
+	)
+	for _, line := range strings.SplitAfter(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == begin:
+			inBlock = true
+		case inBlock && trimmed == end:
+			inBlock = false
+		case !inBlock:
+			sb.WriteString(line)
+		}
+	}
+	return sb.String()
+}
+
+func render(content, tag string, entries []Entry, eol string) string {
+	if len(entries) == 0 {
+		return content
+	}
+
+	begin, end := markers(tag)
+
+	var sb strings.Builder
+	sb.WriteString(content)
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		sb.WriteString(eol)
+	}
+	sb.WriteString(begin + eol)
+	for _, e := range entries {
+		if len(e.Names) == 0 {
+			continue
+		}
+		sb.WriteString(e.IP + "\t" + strings.Join(e.Names, " ") + eol)
+	}
+	sb.WriteString(end + eol)
+	return sb.String()
+}
+
+func writeAtomic(path string, data []byte) error {
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".hosts-*")
+	if err != nil {
+		// the directory may not be writable even though the file is, such as
+		// a hosts file bind mounted into a container
+		return os.WriteFile(path, data, perm)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return os.WriteFile(path, data, perm)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package hostsfile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testTag = "test"
+
+var (
+	testBegin, testEnd = markers(testTag)
+	testEntries        = []Entry{
+		{IP: "127.0.0.1", Names: []string{"app.example.com", "app"}},
+		{IP: "127.0.0.2", Names: []string{"api.example.com"}},
+	}
+	testBlock = testBegin + "\n" +
+		"127.0.0.1\tapp.example.com app\n" +
+		"127.0.0.2\tapi.example.com\n" +
+		testEnd + "\n"
+)
+
+func TestUpdate(t *testing.T) {
+	cases := []struct {
+		name     string
+		existing *string // nil for a missing file
+		entries  []Entry
+		expected string
+	}{
+		{
+			name:     "create missing file",
+			entries:  testEntries,
+			expected: testBlock,
+		},
+		{
+			name:     "insert after existing entries",
+			existing: ptr("127.0.0.1\tlocalhost\n"),
+			entries:  testEntries,
+			expected: "127.0.0.1\tlocalhost\n" + testBlock,
+		},
+		{
+			name:     "insert after content without trailing newline",
+			existing: ptr("127.0.0.1\tlocalhost"),
+			entries:  testEntries,
+			expected: "127.0.0.1\tlocalhost\n" + testBlock,
+		},
+		{
+			name:     "replace existing block",
+			existing: ptr("127.0.0.1\tlocalhost\n" + testBegin + "\n10.0.0.1\told.example.com\n" + testEnd + "\n::1\tlocalhost\n"),
+			entries:  testEntries,
+			expected: "127.0.0.1\tlocalhost\n::1\tlocalhost\n" + testBlock,
+		},
+		{
+			name:     "remove block without entries",
+			existing: ptr("127.0.0.1\tlocalhost\n" + testBlock),
+			expected: "127.0.0.1\tlocalhost\n",
+		},
+		{
+			name:     "skip entries without names",
+			existing: ptr(""),
+			entries:  append([]Entry{{IP: "127.0.0.3"}}, testEntries...),
+			expected: testBlock,
+		},
+		{
+			name:     "keep blocks of other tags",
+			existing: ptr("# BEGIN other managed block, do not edit\n10.0.0.1\tother\n# END other managed block\n"),
+			entries:  testEntries,
+			expected: "# BEGIN other managed block, do not edit\n10.0.0.1\tother\n# END other managed block\n" + testBlock,
+		},
+		{
+			name:     "keep line endings",
+			existing: ptr("127.0.0.1\tlocalhost\r\n"),
+			entries:  testEntries[1:],
+			expected: "127.0.0.1\tlocalhost\r\n" + testBegin + "\r\n127.0.0.2\tapi.example.com\r\n" + testEnd + "\r\n",
+		},
+		{
+			name:     "recover unterminated block",
+			existing: ptr("127.0.0.1\tlocalhost\n" + testBegin + "\n10.0.0.1\told.example.com\n"),
+			entries:  testEntries,
+			expected: "127.0.0.1\tlocalhost\n" + testBlock,
+		},
+		{
+			name:     "recover duplicated blocks",
+			existing: ptr(testBlock + "127.0.0.1\tlocalhost\n" + testBlock),
+			entries:  testEntries[:1],
+			expected: "127.0.0.1\tlocalhost\n" + testBegin + "\n127.0.0.1\tapp.example.com app\n" + testEnd + "\n",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "hosts")
+			if tc.existing != nil {
+				if err := os.WriteFile(path, []byte(*tc.existing), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := Update(path, testTag, tc.entries); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := readFile(t, path); got != tc.expected {
+				t.Fatalf("unexpected content:\n%q\nexpected:\n%q", got, tc.expected)
+			}
+
+			// updating again with the same entries is a no-op
+			if err := Update(path, testTag, tc.entries); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := readFile(t, path); got != tc.expected {
+				t.Fatalf("second update changed the content:\n%q", got)
+			}
+		})
+	}
+}
+
+func TestRemove(t *testing.T) {
+	dir := t.TempDir()
+
+	missing := filepath.Join(dir, "missing")
+	if err := Remove(missing, testTag); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Fatal("expected removing from a missing file not to create it")
+	}
+
+	path := filepath.Join(dir, "hosts")
+	if err := os.WriteFile(path, []byte("127.0.0.1\tlocalhost\n"+testBlock), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Remove(path, testTag); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := readFile(t, path); got != "127.0.0.1\tlocalhost\n" {
+		t.Fatalf("unexpected content after removal: %q", got)
+	}
+}
+
+func TestUpdateAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hosts")
+	if err := os.WriteFile(path, []byte("127.0.0.1\tlocalhost\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Update(path, testTag, testEntries); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := after.Mode().Perm(); perm != 0600 {
+		t.Fatalf("expected permissions to be kept, got %o", perm)
+	}
+	if os.SameFile(before, after) {
+		t.Fatal("expected the file to be replaced rather than written in place")
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), ".hosts-") {
+			t.Fatalf("temporary file %s was left behind", f.Name())
+		}
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,245 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+// 2024-01-01 is a monday
+func at(day, hour, minute int) time.Time {
+	return time.Date(2024, time.January, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestParse(t *testing.T) {
+	type check struct {
+		t      time.Time
+		active bool
+	}
+	cases := []struct {
+		name   string
+		expr   string
+		checks []check
+	}{
+		{
+			name: "always",
+			expr: "* * * * *",
+			checks: []check{
+				{at(1, 0, 0), true},
+				{at(6, 23, 59), true},
+			},
+		},
+		{
+			name: "hour range",
+			expr: "* 9-17 * * *",
+			checks: []check{
+				{at(1, 8, 59), false},
+				{at(1, 9, 0), true},
+				{at(1, 17, 59), true},
+				{at(1, 18, 0), false},
+			},
+		},
+		{
+			name: "list",
+			expr: "0,30 * * * *",
+			checks: []check{
+				{at(1, 10, 0), true},
+				{at(1, 10, 30), true},
+				{at(1, 10, 15), false},
+			},
+		},
+		{
+			name: "step over all",
+			expr: "*/15 * * * *",
+			checks: []check{
+				{at(1, 10, 0), true},
+				{at(1, 10, 45), true},
+				{at(1, 10, 10), false},
+			},
+		},
+		{
+			name: "step over range",
+			expr: "10-30/10 * * * *",
+			checks: []check{
+				{at(1, 10, 10), true},
+				{at(1, 10, 30), true},
+				{at(1, 10, 40), false},
+				{at(1, 10, 15), false},
+			},
+		},
+		{
+			name: "step from a value",
+			expr: "50/5 * * * *",
+			checks: []check{
+				{at(1, 10, 50), true},
+				{at(1, 10, 55), true},
+				{at(1, 10, 45), false},
+			},
+		},
+		{
+			name: "day names",
+			expr: "* * * * mon-fri",
+			checks: []check{
+				{at(1, 12, 0), true},
+				{at(5, 12, 0), true},
+				{at(6, 12, 0), false},
+				{at(7, 12, 0), false},
+			},
+		},
+		{
+			name: "names are case insensitive",
+			expr: "* * * JAN SAT",
+			checks: []check{
+				{at(6, 12, 0), true},
+				{at(7, 12, 0), false},
+			},
+		},
+		{
+			name: "month names",
+			expr: "* * * feb-dec *",
+			checks: []check{
+				{at(1, 12, 0), false},
+				{time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC), true},
+			},
+		},
+		{
+			name: "sunday as 0",
+			expr: "* * * * 0",
+			checks: []check{
+				{at(7, 12, 0), true},
+				{at(6, 12, 0), false},
+			},
+		},
+		{
+			name: "sunday as 7",
+			expr: "* * * * 7",
+			checks: []check{
+				{at(7, 12, 0), true},
+				{at(1, 12, 0), false},
+			},
+		},
+		{
+			name: "range ending on 7",
+			expr: "* * * * 5-7",
+			checks: []check{
+				{at(5, 12, 0), true},
+				{at(7, 12, 0), true},
+				{at(1, 12, 0), false},
+			},
+		},
+		{
+			name: "day of month or day of week",
+			expr: "* * 1 * fri",
+			checks: []check{
+				{at(1, 12, 0), true},
+				{at(5, 12, 0), true},
+				{at(2, 12, 0), false},
+			},
+		},
+		{
+			name: "day of month only",
+			expr: "* * 1-2 * *",
+			checks: []check{
+				{at(2, 12, 0), true},
+				{at(3, 12, 0), false},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := Parse(tc.expr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if s.String() != tc.expr {
+				t.Fatalf("expected %q, got %q", tc.expr, s.String())
+			}
+			for _, c := range tc.checks {
+				if got := s.Active(c.t); got != c.active {
+					t.Fatalf("expected active %t at %s, got %t", c.active, c.t.Format(time.RFC1123), got)
+				}
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	cases := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"17-9 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"* * * foo *",
+		"1,,2 * * * *",
+	}
+
+	for _, expr := range cases {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := Parse(expr); err == nil {
+				t.Fatalf("expected %q to be rejected", expr)
+			}
+		})
+	}
+}
+
+func TestNextChange(t *testing.T) {
+	cases := []struct {
+		name     string
+		expr     string
+		from     time.Time
+		expected time.Time
+		ok       bool
+	}{
+		{
+			name:     "window opens",
+			expr:     "* 9-17 * * *",
+			from:     at(1, 8, 30).Add(time.Second * 15),
+			expected: at(1, 9, 0),
+			ok:       true,
+		},
+		{
+			name:     "window closes",
+			expr:     "* 9-17 * * *",
+			from:     at(1, 12, 0),
+			expected: at(1, 18, 0),
+			ok:       true,
+		},
+		{
+			name:     "weekend skipped",
+			expr:     "* * * * mon-fri",
+			from:     at(5, 12, 0),
+			expected: at(6, 0, 0),
+			ok:       true,
+		},
+		{
+			name: "never changes",
+			expr: "* * * * *",
+			from: at(1, 12, 0),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := Parse(tc.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			next, ok := s.NextChange(tc.from)
+			if ok != tc.ok {
+				t.Fatalf("expected ok %t, got %t", tc.ok, ok)
+			}
+			if !next.Equal(tc.expected) {
+				t.Fatalf("expected next change at %s, got %s", tc.expected, next)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"embed"
+	"os"
 	"runtime"
 
 	binding "kon.nect.sh/phantom/phantom"
@@ -21,6 +22,15 @@ var assets embed.FS
 var icon []byte
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "connect":
+			os.Exit(binding.RunConnect(os.Args[2:]))
+		case "run":
+			os.Exit(binding.RunProcess(os.Args[2:]))
+		}
+	}
+
 	// Create an instance of the app structure
 	app := &binding.Application{}
 	helper := &binding.Helper{}
@@ -0,0 +1,132 @@
+package phantom
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+type accessList struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+func parseRules(rules []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(rules))
+	for _, r := range rules {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		if !strings.Contains(r, "/") {
+			ip := net.ParseIP(r)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", r)
+			}
+			if ip.To4() != nil {
+				r += "/32"
+			} else {
+				r += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", r, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func newAccessList(l Listener) (*accessList, error) {
+	allow, err := parseRules(l.Allow)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing allow rules: %w", err)
+	}
+	deny, err := parseRules(l.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing deny rules: %w", err)
+	}
+	return &accessList{
+		allow: allow,
+		deny:  deny,
+	}, nil
+}
+
+// restricted reports whether only known sources may connect. Deny rules alone
+// leave every other address open, so this takes at least one allow rule.
+func (a *accessList) restricted() bool {
+	return len(a.allow) > 0
+}
+
+// deny rules take precedence; when allow rules are present, the source must match one of them
+func (a *accessList) permit(ip net.IP) bool {
+	for _, n := range a.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(a.allow) == 0 {
+		return true
+	}
+	for _, n := range a.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func isLoopbackListen(listen string) bool {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// checkPublicListen refuses to bind a non-loopback address that any source could
+// connect to, when public listens are restricted
+func checkPublicListen(restrict bool, listen string, acl *accessList) error {
+	if restrict && !acl.restricted() && !isLoopbackListen(listen) {
+		return fmt.Errorf("refusing to listen on non-loopback address %s without allow rules", listen)
+	}
+	return nil
+}
+
+// filteredListener checks the source address of every accepted connection
+// before handing it off, so rejected clients never cause a gateway dial.
+type filteredListener struct {
+	net.Listener
+	logger   *zap.Logger
+	acl      *accessList
+	rejected *atomic.Uint64
+}
+
+var _ net.Listener = (*filteredListener)(nil)
+
+func (f *filteredListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := f.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		var ip net.IP
+		if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+			ip = addr.IP
+		}
+		if ip != nil && f.acl.permit(ip) {
+			return conn, nil
+		}
+		f.rejected.Add(1)
+		f.logger.Warn("Rejected connection by access rules", zap.String("remote", conn.RemoteAddr().String()))
+		conn.Close()
+	}
+}
@@ -0,0 +1,90 @@
+package phantom
+
+import (
+	"net"
+	"testing"
+)
+
+func TestCheckPublicListen(t *testing.T) {
+	cases := []struct {
+		name     string
+		restrict bool
+		listen   string
+		allow    []string
+		deny     []string
+		refused  bool
+	}{
+		{
+			name:     "unrestricted",
+			restrict: false,
+			listen:   "0.0.0.0:8080",
+		},
+		{
+			name:     "loopback without rules",
+			restrict: true,
+			listen:   "127.0.0.1:8080",
+		},
+		{
+			name:     "localhost without rules",
+			restrict: true,
+			listen:   "localhost:8080",
+		},
+		{
+			name:     "public without rules",
+			restrict: true,
+			listen:   "0.0.0.0:8080",
+			refused:  true,
+		},
+		{
+			name:     "public with deny rules only",
+			restrict: true,
+			listen:   "0.0.0.0:8080",
+			deny:     []string{"10.0.0.0/8"},
+			refused:  true,
+		},
+		{
+			name:     "public with allow rules",
+			restrict: true,
+			listen:   "[::]:8080",
+			allow:    []string{"192.168.1.0/24"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			acl, err := newAccessList(Listener{Allow: tc.allow, Deny: tc.deny})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = checkPublicListen(tc.restrict, tc.listen, acl)
+			if tc.refused && err == nil {
+				t.Fatal("expected the listen to be refused")
+			}
+			if !tc.refused && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestAccessListPermit(t *testing.T) {
+	acl, err := newAccessList(Listener{
+		Allow: []string{"192.168.1.0/24", "::1"},
+		Deny:  []string{"192.168.1.13"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]bool{
+		"192.168.1.10": true,
+		"192.168.1.13": false,
+		"192.168.2.1":  false,
+		"::1":          true,
+	}
+	for ip, expected := range cases {
+		if got := acl.permit(net.ParseIP(ip)); got != expected {
+			t.Fatalf("expected permit(%s) to be %t, got %t", ip, expected, got)
+		}
+	}
+}
@@ -5,6 +5,11 @@ import (
 	"encoding/json"
 	"os"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"kon.nect.sh/phantom/internal/devca"
+	"kon.nect.sh/phantom/internal/schedule"
 
 	"kon.nect.sh/specter/overlay"
 	"kon.nect.sh/specter/spec/rtt"
@@ -28,10 +33,29 @@ type Application struct {
 	cliCtx       context.Context
 	cliCtxCancel context.CancelFunc
 	forwarders   *skipmap.StringMap[*forwarder] // needed to start forwarders concurrently
+	gateways     *gatewayPool
+	retries      map[string]context.CancelFunc
+	scheduled    map[string]bool      // last evaluated state of each schedule
+	published    map[string]time.Time // when each tunnel was published, for its TTL
+	expired      map[string]bool      // tunnels unpublished after their TTL, withheld until published by hand
+	draining     sync.WaitGroup
+	effects      *effectQueue
+	transports   *transportMemo
+	caMu         sync.Mutex
+	ca           *devca.Authority
+	dns          *dnsServer
+	dnsRecords   atomic.Pointer[[]dnsRecord]
+	hostsMu      sync.Mutex
+	templatesMu  sync.Mutex
 }
 
 func (app *Application) OnStartup(ctx context.Context) {
 	app.forwarders = skipmap.NewString[*forwarder]()
+	app.retries = make(map[string]context.CancelFunc)
+	app.scheduled = make(map[string]bool)
+	app.published = make(map[string]time.Time)
+	app.expired = make(map[string]bool)
+	app.effects = newEffectQueue()
 	app.appCtx = ctx
 
 	setupPath(ctx)
@@ -56,6 +80,8 @@ func (app *Application) OnStartup(ctx context.Context) {
 	}
 
 	app.logger = logger
+	app.transports = newTransportMemo()
+	app.gateways = newGatewayPool(app.appCtx, logger, app.transports)
 
 	app.stateMu.Lock()
 	defer app.stateMu.Unlock()
@@ -88,15 +114,34 @@ func (app *Application) OnStartup(ctx context.Context) {
 		return
 	}
 
+	if err := app.applyProxyConfig(phantomCfg.Proxy); err != nil {
+		app.logger.Error("Ignoring invalid proxy configuration", zap.Error(err))
+	}
+
 	app.specterCfg = specterCfg
 	app.phantomCfg = phantomCfg
 
+	if err := app.applyDNSConfig(phantomCfg.DNS); err != nil {
+		app.logger.Error("Failed to start DNS server", zap.Error(err))
+	}
+
+	app.cleanupHostsFile(phantomCfg.HostsFile)
+
 	runtime.EventsOnce(app.appCtx, "broker:Ready", app.onBrokerReady)
 }
 
 func (app *Application) OnShutdown(ctx context.Context) {
+	app.stateMu.Lock()
+	app.recordRunningForwarders()
+	app.applyDNSConfig(DNSConfig{})
+	app.stateMu.Unlock()
+
 	app.StopClient()
 	app.StopAllForwarders()
+	app.draining.Wait()
+	app.effects.close()
+	app.cleanupHostsFile(app.phantomCfg.HostsFile)
+	app.cleanupAllTemplates()
 	app.logger.Sync()
 }
 
@@ -115,7 +160,9 @@ func (app *Application) onBrokerReady(_ ...interface{}) {
 			}
 		}()
 	}
-	if app.phantomCfg.ListenOnStart {
-		go app.StartAllForwarders()
-	}
+	go app.runTunnelExpiry(app.appCtx)
+	go func() {
+		app.autostartForwarders()
+		app.runScheduler(app.appCtx, schedule.System)
+	}()
 }
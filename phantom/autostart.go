@@ -0,0 +1,167 @@
+package phantom
+
+import (
+	"context"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"go.uber.org/zap"
+)
+
+// app.stateMu must be held
+func (app *Application) configuredListener(listen string) (Listener, bool) {
+	for _, l := range app.phantomCfg.Listeners {
+		if l.Listen == listen {
+			return l, true
+		}
+	}
+	return Listener{}, false
+}
+
+// app.stateMu must be held
+func (app *Application) autostartListeners(now time.Time) []Listener {
+	var (
+		cfg      = app.phantomCfg
+		previous = make(map[string]bool)
+		groups   = make(map[string]bool)
+		toStart  = make([]Listener, 0)
+	)
+	if cfg.RestoreForwarders {
+		for _, listen := range cfg.LastRunning {
+			previous[listen] = true
+		}
+	}
+	for _, g := range cfg.Groups {
+		if g.Autostart {
+			groups[g.Name] = true
+		}
+	}
+	for _, l := range cfg.Listeners {
+		if _, ok := app.forwarders.Load(l.Listen); ok {
+			continue
+		}
+		// outside of its schedule, the forwarder is left for the scheduler to start once the window opens
+		if !scheduleActive(l.Schedule, now) {
+			continue
+		}
+		if cfg.ListenOnStart || l.Autostart || previous[l.Listen] || (l.Group != "" && groups[l.Group]) {
+			toStart = append(toStart, l)
+		}
+	}
+	return toStart
+}
+
+// autostartForwarders starts every forwarder that should be running at launch.
+// Forwarders are started independently, and the ones that fail keep retrying
+// in the background while the others come up.
+func (app *Application) autostartForwarders() {
+	app.stateMu.Lock()
+	defer app.stateMu.Unlock()
+
+	toStart := app.autostartListeners(time.Now())
+	if len(toStart) == 0 {
+		return
+	}
+
+	runtime.EventsEmit(app.appCtx, "forwarders:Starting")
+
+	results, _ := app.startBatch(toStart, false)
+	for _, r := range results {
+		if r.Outcome != StartFailed {
+			continue
+		}
+		runtime.EventsEmit(app.appCtx, "forwarder:Failed", r.Listen, r.Error)
+		app.scheduleRetry(r.Listen)
+	}
+
+	app.emitBatchResult(results)
+}
+
+// app.stateMu must be held
+func (app *Application) scheduleRetry(listen string) {
+	if _, ok := app.retries[listen]; ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(app.appCtx)
+	app.retries[listen] = cancel
+	go app.retryForwarder(ctx, listen)
+}
+
+// app.stateMu must be held
+func (app *Application) cancelRetry(listen string) {
+	if cancel, ok := app.retries[listen]; ok {
+		cancel()
+		delete(app.retries, listen)
+	}
+}
+
+// app.stateMu must be held
+func (app *Application) cancelAllRetries() {
+	for listen, cancel := range app.retries {
+		cancel()
+		delete(app.retries, listen)
+	}
+}
+
+func (app *Application) retryForwarder(ctx context.Context, listen string) {
+	wait := &backoff{min: redialMinBackoff, max: redialMaxBackoff}
+
+	for {
+		t := time.NewTimer(wait.next())
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+
+		app.stateMu.Lock()
+		if ctx.Err() != nil {
+			app.stateMu.Unlock()
+			return
+		}
+
+		l, configured := app.configuredListener(listen)
+		_, running := app.forwarders.Load(listen)
+		if !configured || running {
+			app.cancelRetry(listen)
+			app.stateMu.Unlock()
+			return
+		}
+
+		f, err := app.startForwarder(l)
+		if err == nil {
+			if err := app.persistBoundAddress(l.Listen, f); err != nil {
+				app.logger.Error("Failed to persist bound address", zap.Object("listener", &f.cfg), zap.Error(err))
+			}
+			app.cancelRetry(listen)
+			if app.isAllForwardersStarted() {
+				runtime.EventsEmit(app.appCtx, "forwarders:Started")
+			}
+			app.stateMu.Unlock()
+			return
+		}
+		app.stateMu.Unlock()
+
+		app.logger.Warn("Failed to start forwarder, will retry", zap.Object("listener", &l), zap.Error(err))
+		runtime.EventsEmit(app.appCtx, "forwarder:Failed", listen, err.Error())
+	}
+}
+
+// app.stateMu must be held
+func (app *Application) recordRunningForwarders() {
+	if !app.phantomCfg.RestoreForwarders {
+		return
+	}
+
+	running := make([]string, 0)
+	app.forwarders.Range(func(listen string, _ *forwarder) bool {
+		running = append(running, listen)
+		return true
+	})
+	app.phantomCfg.LastRunning = running
+
+	if err := app.persistPhantomConfig(app.phantomCfg); err != nil {
+		app.logger.Error("Failed to persist running forwarders", zap.Error(err))
+	}
+}
@@ -0,0 +1,102 @@
+package phantom
+
+import (
+	"fmt"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"go.uber.org/zap"
+)
+
+type StartOutcome string
+
+const (
+	StartStarted    StartOutcome = "started"
+	StartFailed     StartOutcome = "failed"
+	StartSkipped    StartOutcome = "skipped"
+	StartRolledBack StartOutcome = "rolledBack"
+)
+
+type ForwarderResult struct {
+	Label   string       `json:"label"`
+	Listen  string       `json:"listen"`
+	Outcome StartOutcome `json:"outcome"`
+	Error   string       `json:"error,omitempty"`
+}
+
+// startBatch starts the listeners concurrently and reports the outcome of each one.
+// If transactional is set and any of them fails, the ones started by this batch
+// are stopped again.
+// app.stateMu must be held
+func (app *Application) startBatch(listeners []Listener, transactional bool) ([]ForwarderResult, error) {
+	var (
+		results  = make([]ForwarderResult, len(listeners))
+		toStart  = make([]Listener, 0, len(listeners))
+		indices  = make([]int, 0, len(listeners))
+		failed   = 0
+		firstErr error
+	)
+
+	for i, l := range listeners {
+		results[i] = ForwarderResult{
+			Label:  l.Label,
+			Listen: l.Listen,
+		}
+		if _, ok := app.forwarders.Load(l.Listen); ok {
+			results[i].Outcome = StartSkipped
+			continue
+		}
+		toStart = append(toStart, l)
+		indices = append(indices, i)
+	}
+
+	if len(toStart) == 0 {
+		return results, nil
+	}
+
+	started, errors := app.startListeners(toStart)
+	for j, err := range errors {
+		r := &results[indices[j]]
+		if err != nil {
+			r.Outcome = StartFailed
+			r.Error = err.Error()
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		r.Outcome = StartStarted
+		r.Listen = started[j].cfg.Listen
+	}
+
+	if failed == 0 {
+		return results, nil
+	}
+
+	if transactional {
+		app.logger.Info("Rolling back forwarders started in failed batch", zap.Int("failed", failed))
+		for j, f := range started {
+			if f == nil {
+				continue
+			}
+			app.stopForwarder(f.cfg, f)
+			runtime.EventsEmit(app.appCtx, "forwarder:Stopped", f.cfg.Listen)
+			results[indices[j]].Outcome = StartRolledBack
+		}
+	}
+
+	return results, fmt.Errorf("%d of %d forwarders failed to start: %w", failed, len(toStart), firstErr)
+}
+
+// emitBatchResult emits the aggregate state after a batch, based on which forwarders are actually running.
+// app.stateMu must be held
+func (app *Application) emitBatchResult(results []ForwarderResult) {
+	switch {
+	case app.isAllForwardersStarted():
+		runtime.EventsEmit(app.appCtx, "forwarders:Started", results)
+	case app.forwarders.Len() == 0:
+		runtime.EventsEmit(app.appCtx, "forwarders:Stopped", results)
+	default:
+		runtime.EventsEmit(app.appCtx, "forwarders:Partial", results)
+	}
+}
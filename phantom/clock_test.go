@@ -0,0 +1,81 @@
+package phantom
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"kon.nect.sh/phantom/internal/schedule"
+)
+
+type testTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+// testClock only moves when the test advances it
+type testClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []testTimer
+	asked  chan time.Duration
+}
+
+var _ schedule.Clock = (*testClock)(nil)
+
+func newTestClock(now time.Time) *testClock {
+	return &testClock{
+		now:   now,
+		asked: make(chan time.Duration, 64),
+	}
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *testClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+	} else {
+		c.timers = append(c.timers, testTimer{at: c.now.Add(d), ch: ch})
+	}
+	c.asked <- d
+	return ch
+}
+
+// advance moves the clock forward and fires the timers that came due
+func (c *testClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.ch <- c.now
+	}
+	c.timers = pending
+}
+
+// waitTimer waits for a timer to be asked for and returns its duration
+func (c *testClock) waitTimer(t *testing.T) time.Duration {
+	t.Helper()
+	select {
+	case d := <-c.asked:
+		return d
+	case <-time.After(time.Second):
+		t.Fatal("no timer was asked for")
+		return 0
+	}
+}
@@ -23,32 +23,102 @@ var (
 )
 
 type PhantomConfig struct {
-	Listeners                 []Listener `json:"listeners"`
-	ListenOnStart             bool       `json:"listenOnStart"`
-	SpecterInsecureSkipVerify bool       `json:"specterInsecure"`
-	ConnectOnStart            bool       `json:"connectOnStart"`
+	Listeners                 []Listener       `json:"listeners"`
+	ListenOnStart             bool             `json:"listenOnStart"`
+	SpecterInsecureSkipVerify bool             `json:"specterInsecure"`
+	ConnectOnStart            bool             `json:"connectOnStart"`
+	RestrictPublicListen      bool             `json:"restrictPublicListen"`
+	Proxy                     ProxyConfig      `json:"proxy"`
+	Groups                    []GroupConfig    `json:"groups,omitempty"`
+	RestoreForwarders         bool             `json:"restoreForwarders"`
+	LastRunning               []string         `json:"lastRunning,omitempty"`
+	DrainTimeout              int              `json:"drainTimeout"` // seconds
+	DNS                       DNSConfig        `json:"dns"`
+	HostsFile                 HostsFileConfig  `json:"hostsFile"`
+	Mirror                    MirrorConfig     `json:"mirror"`
+	TunnelMeta                []TunnelMetadata `json:"tunnelMeta,omitempty"`
 }
 
 func (app *Application) UpdatePhantomConfig(cfg PhantomConfig) error {
 	app.stateMu.Lock()
 	defer app.stateMu.Unlock()
 
+	cfg.Proxy = cfg.Proxy.withSavedPassword(app.phantomCfg.Proxy)
+	if err := app.applyProxyConfig(cfg.Proxy); err != nil {
+		return err
+	}
+
+	if cfg.DNS != app.phantomCfg.DNS {
+		if err := app.applyDNSConfig(cfg.DNS); err != nil {
+			return err
+		}
+	}
+
+	// mirrored forwarders come and go with the registered hostnames, so the copy sent by the UI may be stale
+	listeners := make([]Listener, 0, len(cfg.Listeners))
+	for _, l := range cfg.Listeners {
+		if !l.Mirrored {
+			listeners = append(listeners, l)
+		}
+	}
+	for _, l := range app.phantomCfg.Listeners {
+		if l.Mirrored {
+			listeners = append(listeners, l)
+		}
+	}
+	cfg.Listeners = listeners
+
 	if err := app.persistPhantomConfig(&cfg); err != nil {
 		return err
 	}
 
+	prevHosts := app.phantomCfg.HostsFile
+	prevMirror := app.phantomCfg.Mirror
 	app.phantomCfg = &cfg
+
+	if cfg.Mirror != prevMirror {
+		app.queueMirrorSync()
+	}
+
+	if cfg.HostsFile != prevHosts {
+		app.effects.push(func() {
+			app.cleanupHostsFile(prevHosts)
+			app.syncHostsFile()
+		})
+	}
 	return nil
 }
 
+// loadPhantomConfig reads the config for the command line modes, which treat a missing file as empty
+func loadPhantomConfig() (*PhantomConfig, error) {
+	cfg := &PhantomConfig{}
+	data, err := os.ReadFile(phantomConfigFile)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("error loading phantom config: %w", err)
+	}
+	return cfg, nil
+}
+
 func (app *Application) persistPhantomConfig(cfg *PhantomConfig) error {
-	fn, err := os.OpenFile(phantomConfigFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	// the config holds the proxy password
+	fn, err := os.OpenFile(phantomConfigFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
 	}
 	defer fn.Close()
 	defer fn.Sync()
 
+	// files created before the password was stored may still be readable by others
+	if err := fn.Chmod(0600); err != nil {
+		return err
+	}
+
 	if err := json.NewEncoder(fn).Encode(cfg); err != nil {
 		return err
 	}
@@ -66,11 +136,14 @@ func setupPath(ctx context.Context) {
 	env := runtime.Environment(ctx)
 
 	if env.BuildType == "dev" {
-		configPath = configdir.LocalConfig("phantom-dev")
+		setConfigPath(configdir.LocalConfig("phantom-dev"))
 	} else {
-		configPath = configdir.LocalConfig("phantom")
+		setConfigPath(configdir.LocalConfig("phantom"))
 	}
+}
 
+func setConfigPath(path string) {
+	configPath = path
 	logPath = filepath.Join(configPath, "logs")
 	specterConfigFile = filepath.Join(configPath, "specter.yaml")
 	phantomConfigFile = filepath.Join(configPath, "phantom.json")
@@ -101,7 +174,7 @@ func ensurePhantomConfig() error {
 	}
 	if _, err := os.Stat(phantomConfigFile); os.IsNotExist(err) {
 		// Create the new config file.
-		fh, err := os.Create(phantomConfigFile)
+		fh, err := os.OpenFile(phantomConfigFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 		if err != nil {
 			return fmt.Errorf("creating phantom config file: %w", err)
 		}
@@ -0,0 +1,169 @@
+package phantom
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"kon.nect.sh/phantom/internal/configdir"
+
+	"go.uber.org/zap"
+)
+
+const connectUsage = "usage: phantom connect [--insecure] [--tcp] [--config dir] [--verbose] <hostname>"
+
+// RunConnect dials a tunnel and pipes it to stdin and stdout, for use as an
+// SSH ProxyCommand and similar. It returns the process exit code.
+func RunConnect(args []string) int {
+	fs := flag.NewFlagSet("connect", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, connectUsage)
+		fs.PrintDefaults()
+	}
+	insecure := fs.Bool("insecure", false, "skip verifying the certificate of the gateway")
+	useTCP := fs.Bool("tcp", false, "connect to the gateway over TLS/TCP instead of QUIC")
+	configDir := fs.String("config", configdir.LocalConfig("phantom"), "phantom config directory, used for the proxy settings")
+	verbose := fs.Bool("verbose", false, "log to stderr")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	logger := cliLogger(*verbose)
+	defer logger.Sync()
+
+	setConfigPath(*configDir)
+	proxy, err := cliProxy()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "phantom: %v\n", err)
+		return 1
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	l := Listener{
+		Hostname: fs.Arg(0),
+		Insecure: *insecure,
+		UseTCP:   *useTCP,
+	}
+
+	conn, err := dialStream(ctx, logger, gatewayOptions{memo: newTransportMemo(), proxy: proxy}, l)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "phantom: %v\n", err)
+		return 1
+	}
+	defer conn.Close()
+
+	if err := pipeStdio(ctx, conn); err != nil {
+		fmt.Fprintf(os.Stderr, "phantom: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// cliLogger logs to stderr, so stdout stays free for the piped connection
+func cliLogger(verbose bool) *zap.Logger {
+	if !verbose {
+		return zap.NewNop()
+	}
+	cfg := zap.NewDevelopmentConfig()
+	cfg.OutputPaths = []string{"stderr"}
+	logger, err := cfg.Build()
+	if err != nil {
+		return zap.NewNop()
+	}
+	return logger
+}
+
+// cliProxy returns the proxy configured in the app, for the TLS/TCP transport
+func cliProxy() (*proxyDialer, error) {
+	cfg, err := loadPhantomConfig()
+	if err != nil {
+		return nil, err
+	}
+	p, err := newProxyDialer(cfg.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy configuration: %w", err)
+	}
+	return p, nil
+}
+
+// dialStream opens a single stream to the tunnel of l, which lives until ctx is done
+func dialStream(ctx context.Context, logger *zap.Logger, opts gatewayOptions, l Listener) (net.Conn, error) {
+	gw, err := dialGateway(ctx, logger, opts, l)
+	if err != nil {
+		return nil, fmt.Errorf("error dialing specter gateway: %w", err)
+	}
+	conn, err := gw.dialer.Dial()
+	if err != nil {
+		return nil, fmt.Errorf("error opening stream to %s: %w", l.Hostname, err)
+	}
+	return conn, nil
+}
+
+func pipeStdio(ctx context.Context, conn net.Conn) error {
+	return pipeConn(ctx, conn, os.Stdin, os.Stdout)
+}
+
+// pipeConn copies in to conn and conn to out. Once in reaches EOF, the write side of conn
+// is closed so the remote sees it, and its output is copied until it closes as well.
+// It returns once both directions are done, or ctx is done.
+func pipeConn(ctx context.Context, conn net.Conn, in io.Reader, out io.Writer) error {
+	upload := make(chan error, 1)
+	download := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(conn, in)
+		if cw, ok := conn.(interface{ CloseWrite() error }); ok && err == nil {
+			cw.CloseWrite()
+		}
+		upload <- err
+	}()
+	go func() {
+		_, err := io.Copy(out, conn)
+		download <- err
+	}()
+
+	for {
+		select {
+		case err := <-upload:
+			upload = nil
+			if err == nil {
+				continue
+			}
+			// nothing more can be sent, so stop waiting for the remote
+			conn.Close()
+			<-download
+			return pipeError(err)
+		case err := <-download:
+			if upload != nil {
+				// the remote is done, stop reading input that has nowhere to go
+				conn.Close()
+				if d, ok := in.(interface{ SetReadDeadline(time.Time) error }); ok && d.SetReadDeadline(time.Now()) == nil {
+					<-upload
+				}
+			}
+			return pipeError(err)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func pipeError(err error) error {
+	if err != nil && !errors.Is(err, net.ErrClosed) && !errors.Is(err, os.ErrDeadlineExceeded) {
+		return err
+	}
+	return nil
+}
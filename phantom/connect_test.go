@@ -0,0 +1,47 @@
+package phantom
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPipeHalfClose(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// the remote only answers once it has read all of the input
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		received, _ := io.ReadAll(conn)
+		time.Sleep(time.Millisecond * 50)
+		conn.Write([]byte("received " + string(received)))
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var out bytes.Buffer
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := pipeConn(ctx, conn, strings.NewReader("hello"), &out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := out.String(); got != "received hello" {
+		t.Fatalf("expected the output of the remote after the input ended, got %q", got)
+	}
+}
@@ -0,0 +1,477 @@
+package phantom
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	defaultDNSListen   = "127.0.0.1:5353"
+	defaultDNSZone     = "phantom.test"
+	dnsAnswerTTL       = 5 // seconds
+	dnsUpstreamTimeout = time.Second * 5
+	dnsMaxMessageSize  = 4096
+)
+
+type DNSConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Listen   string `json:"listen,omitempty"`   // defaults to 127.0.0.1:5353
+	Zone     string `json:"zone,omitempty"`     // defaults to phantom.test
+	Upstream string `json:"upstream,omitempty"` // defaults to the system resolver
+}
+
+func (c DNSConfig) listen() string {
+	if c.Listen == "" {
+		return defaultDNSListen
+	}
+	return c.Listen
+}
+
+func (c DNSConfig) zone() string {
+	if c.Zone == "" {
+		return defaultDNSZone
+	}
+	return strings.ToLower(strings.Trim(c.Zone, "."))
+}
+
+var resolvConfPath = "/etc/resolv.conf"
+
+// upstream returns the resolver other names are relayed to. Without one configured this is
+// the first nameserver of the system, or empty where there is none to relay to, such as on Windows.
+func (c DNSConfig) upstream() string {
+	if c.Upstream != "" {
+		if _, _, err := net.SplitHostPort(c.Upstream); err != nil {
+			return net.JoinHostPort(c.Upstream, "53")
+		}
+		return c.Upstream
+	}
+	servers, err := systemNameservers(resolvConfPath)
+	if err != nil || len(servers) == 0 {
+		return ""
+	}
+	return servers[0]
+}
+
+func systemNameservers(path string) ([]string, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	servers := make([]string, 0)
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		addr, err := netip.ParseAddr(fields[1])
+		if err != nil {
+			continue
+		}
+		servers = append(servers, net.JoinHostPort(addr.WithZone("").String(), "53"))
+	}
+	return servers, scanner.Err()
+}
+
+// dnsServer answers queries for the local names of running forwarders, and for
+// names within its zone, with the address the forwarder is bound to. Everything
+// else is relayed to the upstream resolver as is, or looked up through the system
+// resolver when there is no upstream to relay to.
+type dnsServer struct {
+	logger   *zap.Logger
+	conn     net.PacketConn
+	zone     string
+	upstream string
+	lookup   func(name string) (net.IP, bool)
+
+	// queries being relayed, so one that is routed back to us by the system resolver is dropped
+	mu       sync.Mutex
+	inflight map[dnsQuery]bool
+}
+
+type dnsQuery struct {
+	id    uint16
+	name  string
+	qtype dnsmessage.Type
+}
+
+func (s *dnsServer) serve() {
+	for {
+		buf := make([]byte, dnsMaxMessageSize)
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			s.logger.Debug("Stopped serving DNS", zap.Error(err))
+			return
+		}
+		go s.handle(buf[:n], addr)
+	}
+}
+
+func (s *dnsServer) close() {
+	s.conn.Close()
+}
+
+func (s *dnsServer) inZone(name string) bool {
+	return name == s.zone || strings.HasSuffix(name, "."+s.zone)
+}
+
+func (s *dnsServer) handle(msg []byte, addr net.Addr) {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(msg)
+	if err != nil {
+		return
+	}
+	q, err := p.Question()
+	if err != nil {
+		return
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(q.Name.String(), "."))
+	ip, found := s.lookup(name)
+	if !found && !s.inZone(name) {
+		key := dnsQuery{hdr.ID, name, q.Type}
+		if !s.begin(key) {
+			s.logger.Debug("Dropping query looped back by the upstream resolver", zap.String("name", name))
+			return
+		}
+		defer s.end(key)
+
+		if s.upstream == "" {
+			s.resolve(hdr, q, name, addr)
+		} else {
+			s.forward(msg, addr)
+		}
+		return
+	}
+
+	resp, err := s.answer(hdr, q, []net.IP{ip}, dnsRCode(found))
+	if err != nil {
+		s.logger.Debug("Failed to build DNS answer", zap.String("name", name), zap.Error(err))
+		return
+	}
+	s.conn.WriteTo(resp, addr)
+}
+
+func (s *dnsServer) begin(key dnsQuery) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.inflight[key] {
+		return false
+	}
+	s.inflight[key] = true
+	return true
+}
+
+func (s *dnsServer) end(key dnsQuery) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.inflight, key)
+}
+
+func dnsRCode(found bool) dnsmessage.RCode {
+	if found {
+		return dnsmessage.RCodeSuccess
+	}
+	return dnsmessage.RCodeNameError
+}
+
+func (s *dnsServer) answer(hdr dnsmessage.Header, q dnsmessage.Question, ips []net.IP, rcode dnsmessage.RCode) ([]byte, error) {
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), dnsmessage.Header{
+		ID:                 hdr.ID,
+		Response:           true,
+		Authoritative:      true,
+		RecursionDesired:   hdr.RecursionDesired,
+		RecursionAvailable: true,
+		RCode:              rcode,
+	})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(q); err != nil {
+		return nil, err
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+
+	rh := dnsmessage.ResourceHeader{
+		Name:  q.Name,
+		Class: dnsmessage.ClassINET,
+		TTL:   dnsAnswerTTL,
+	}
+	for _, ip := range ips {
+		switch {
+		case rcode != dnsmessage.RCodeSuccess:
+		case q.Type == dnsmessage.TypeA && ip.To4() != nil:
+			var a dnsmessage.AResource
+			copy(a.A[:], ip.To4())
+			if err := b.AResource(rh, a); err != nil {
+				return nil, err
+			}
+		case q.Type == dnsmessage.TypeAAAA && ip.To4() == nil:
+			var aaaa dnsmessage.AAAAResource
+			copy(aaaa.AAAA[:], ip.To16())
+			if err := b.AAAAResource(rh, aaaa); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return b.Finish()
+}
+
+// resolve answers address queries through the resolver of the system, for when there is
+// no nameserver to relay to. Other query types are refused.
+func (s *dnsServer) resolve(hdr dnsmessage.Header, q dnsmessage.Question, name string, addr net.Addr) {
+	var (
+		ips   []net.IP
+		rcode = dnsmessage.RCodeSuccess
+	)
+	switch q.Type {
+	case dnsmessage.TypeA, dnsmessage.TypeAAAA:
+		network := "ip4"
+		if q.Type == dnsmessage.TypeAAAA {
+			network = "ip6"
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), dnsUpstreamTimeout)
+		defer cancel()
+
+		var (
+			err    error
+			dnsErr *net.DNSError
+		)
+		ips, err = net.DefaultResolver.LookupIP(ctx, network, name)
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			rcode = dnsmessage.RCodeNameError
+		} else if err != nil {
+			s.logger.Debug("System resolver failed", zap.String("name", name), zap.Error(err))
+			rcode = dnsmessage.RCodeServerFailure
+		}
+	default:
+		rcode = dnsmessage.RCodeRefused
+	}
+
+	resp, err := s.answer(hdr, q, ips, rcode)
+	if err != nil {
+		s.logger.Debug("Failed to build DNS answer", zap.String("name", name), zap.Error(err))
+		return
+	}
+	s.conn.WriteTo(resp, addr)
+}
+
+func (s *dnsServer) forward(msg []byte, addr net.Addr) {
+	conn, err := net.DialTimeout("udp", s.upstream, dnsUpstreamTimeout)
+	if err != nil {
+		s.logger.Debug("Failed to reach upstream resolver", zap.String("upstream", s.upstream), zap.Error(err))
+		return
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(dnsUpstreamTimeout))
+	if _, err := conn.Write(msg); err != nil {
+		return
+	}
+	buf := make([]byte, dnsMaxMessageSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		s.logger.Debug("No answer from upstream resolver", zap.String("upstream", s.upstream), zap.Error(err))
+		return
+	}
+	s.conn.WriteTo(buf[:n], addr)
+}
+
+// forwarderIP is the address local clients should connect to for a bound forwarder
+func forwarderIP(bound string) net.IP {
+	host, _, err := net.SplitHostPort(bound)
+	if err != nil {
+		return net.IPv4(127, 0, 0, 1)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsUnspecified() {
+		return net.IPv4(127, 0, 0, 1)
+	}
+	return ip
+}
+
+// localNames returns the names a forwarder answers to in DNS: the first label of
+// each hostname within zone, and its local http route hosts. Tunnel hostnames are
+// never answered, as forwarders dial their gateway by the same names.
+func (l *Listener) localNames(zone string, tunnels map[string]bool) []string {
+	names := make([]string, 0)
+	for _, h := range l.hostnames() {
+		host, _ := splitHostname(h)
+		if label, _, ok := strings.Cut(host, "."); ok && zone != "" && label != "*" {
+			names = append(names, label+"."+zone)
+		}
+	}
+	for _, r := range l.HTTPRoutes {
+		if host := strings.ToLower(r.Host); host != "" && !tunnels[host] {
+			names = append(names, host)
+		}
+	}
+	return names
+}
+
+// app.stateMu must be held
+func (app *Application) tunnelHosts() map[string]bool {
+	hosts := make(map[string]bool)
+	for _, l := range app.phantomCfg.Listeners {
+		for _, h := range l.hostnames() {
+			host, _ := splitHostname(h)
+			hosts[host] = true
+		}
+	}
+	return hosts
+}
+
+func matchName(pattern, name string) bool {
+	if isWildcardHostname(pattern) {
+		label := strings.TrimSuffix(name, pattern[1:])
+		return label != name && label != "" && !strings.Contains(label, ".")
+	}
+	return pattern == name
+}
+
+type dnsRecord struct {
+	pattern string
+	ip      net.IP
+}
+
+// lookupForwarder is called for every query, so it reads the records snapshot instead of taking app.stateMu
+func (app *Application) lookupForwarder(name string) (net.IP, bool) {
+	records := app.dnsRecords.Load()
+	if records == nil {
+		return nil, false
+	}
+	for _, r := range *records {
+		if matchName(r.pattern, name) {
+			return r.ip, true
+		}
+	}
+	return nil, false
+}
+
+// app.stateMu must be held
+func (app *Application) refreshDNSRecords(zone string) {
+	records := make([]dnsRecord, 0)
+	tunnels := app.tunnelHosts()
+	app.forwarders.Range(func(_ string, f *forwarder) bool {
+		ip := forwarderIP(f.bound)
+		for _, n := range f.cfg.localNames(zone, tunnels) {
+			records = append(records, dnsRecord{pattern: n, ip: ip})
+		}
+		return true
+	})
+	app.dnsRecords.Store(&records)
+}
+
+// app.stateMu must be held
+func (app *Application) applyDNSConfig(cfg DNSConfig) error {
+	if app.dns != nil {
+		app.dns.close()
+		app.dns = nil
+	}
+	if !cfg.Enabled {
+		return nil
+	}
+	app.refreshDNSRecords(cfg.zone())
+
+	conn, err := net.ListenPacket("udp", cfg.listen())
+	if err != nil {
+		return fmt.Errorf("error starting dns server: %w", err)
+	}
+
+	upstream := cfg.upstream()
+	app.dns = &dnsServer{
+		logger:   app.logger.With(zap.String("dns", cfg.listen())),
+		conn:     conn,
+		zone:     cfg.zone(),
+		upstream: upstream,
+		lookup:   app.lookupForwarder,
+		inflight: make(map[dnsQuery]bool),
+	}
+	go app.dns.serve()
+
+	if upstream == "" {
+		upstream = "system"
+	}
+	app.logger.Info("DNS server started", zap.String("listen", cfg.listen()), zap.String("zone", cfg.zone()), zap.String("upstream", upstream))
+	return nil
+}
+
+// dnsDomains returns the zone and the local http route hosts outside of it, the only
+// names the resolver of the system should send to the built-in DNS server.
+// app.stateMu must be held
+func (app *Application) dnsDomains(zone string) []string {
+	seen := map[string]bool{zone: true}
+	domains := []string{zone}
+	tunnels := app.tunnelHosts()
+	for _, l := range app.phantomCfg.Listeners {
+		for _, n := range l.localNames(zone, tunnels) {
+			if seen[n] || n == zone || strings.HasSuffix(n, "."+zone) {
+				continue
+			}
+			seen[n] = true
+			domains = append(domains, n)
+		}
+	}
+	return domains
+}
+
+// GetDNSSetup returns the commands to point the resolver of this OS at the
+// built-in DNS server, for the zone and the local http route hosts
+func (app *Application) GetDNSSetup() string {
+	app.stateMu.RLock()
+	defer app.stateMu.RUnlock()
+
+	cfg := app.phantomCfg.DNS
+	domains := app.dnsDomains(cfg.zone())
+	host, port, err := net.SplitHostPort(cfg.listen())
+	if err != nil {
+		return err.Error()
+	}
+
+	var sb strings.Builder
+	switch runtime.GOOS {
+	case "darwin":
+		sb.WriteString("sudo mkdir -p /etc/resolver\n")
+		for _, d := range domains {
+			fmt.Fprintf(&sb, "printf 'nameserver %s\\nport %s\\n' | sudo tee /etc/resolver/%s\n", host, port, d)
+		}
+	case "linux":
+		sb.WriteString("# requires systemd-resolved 246 or newer\n")
+		fmt.Fprintf(&sb, "sudo resolvectl dns lo %s\n", net.JoinHostPort(host, port))
+		sb.WriteString("sudo resolvectl domain lo")
+		for _, d := range domains {
+			fmt.Fprintf(&sb, " '~%s'", d)
+		}
+		sb.WriteString("\n")
+	case "windows":
+		if port != "53" {
+			sb.WriteString("# the Name Resolution Policy Table only supports port 53, change the dns listen address first\n")
+		}
+		for _, d := range domains {
+			fmt.Fprintf(&sb, "Add-DnsClientNrptRule -Namespace \".%s\" -NameServers \"%s\"\n", d, host)
+		}
+	default:
+		fmt.Fprintf(&sb, "# point the resolver for %s at %s\n", strings.Join(domains, ", "), net.JoinHostPort(host, port))
+	}
+	return sb.String()
+}
@@ -0,0 +1,286 @@
+package phantom
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func writeResolvConf(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSystemNameservers(t *testing.T) {
+	path := writeResolvConf(t, `# generated
+search example.com
+nameserver 127.0.0.53
+nameserver fe80::1%eth0
+nameserver not-an-address
+options edns0
+`)
+
+	servers, err := systemNameservers(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"127.0.0.53:53", "[fe80::1]:53"}
+	if len(servers) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, servers)
+	}
+	for i := range expected {
+		if servers[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, servers)
+		}
+	}
+}
+
+func TestDNSConfigUpstream(t *testing.T) {
+	withServer := writeResolvConf(t, "nameserver 192.0.2.1\n")
+	withoutServer := writeResolvConf(t, "search example.com\n")
+
+	cases := []struct {
+		name     string
+		cfg      DNSConfig
+		resolv   string
+		expected string
+	}{
+		{
+			name:     "configured with port",
+			cfg:      DNSConfig{Upstream: "1.1.1.1:5353"},
+			resolv:   withServer,
+			expected: "1.1.1.1:5353",
+		},
+		{
+			name:     "configured without port",
+			cfg:      DNSConfig{Upstream: "1.1.1.1"},
+			resolv:   withServer,
+			expected: "1.1.1.1:53",
+		},
+		{
+			name:     "system nameserver by default",
+			resolv:   withServer,
+			expected: "192.0.2.1:53",
+		},
+		{
+			name:   "no system nameserver",
+			resolv: withoutServer,
+		},
+		{
+			name:   "no resolv.conf",
+			resolv: filepath.Join(t.TempDir(), "missing"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			prev := resolvConfPath
+			resolvConfPath = tc.resolv
+			t.Cleanup(func() { resolvConfPath = prev })
+
+			if got := tc.cfg.upstream(); got != tc.expected {
+				t.Fatalf("expected upstream %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func listenUDP(t *testing.T) net.PacketConn {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func buildQuery(t *testing.T, id uint16, name string) []byte {
+	t.Helper()
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName(name),
+		Type:  dnsmessage.TypeA,
+		Class: dnsmessage.ClassINET,
+	})
+	msg, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func exchange(t *testing.T, server net.Addr, msg []byte) dnsmessage.Message {
+	t.Helper()
+	conn, err := net.Dial("udp", server.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(time.Second * 2))
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, dnsMaxMessageSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("no answer: %v", err)
+	}
+	var resp dnsmessage.Message
+	if err := resp.Unpack(buf[:n]); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestDNSServer(t *testing.T) {
+	// the upstream answers every query with 192.0.2.53
+	upstream := listenUDP(t)
+	go func() {
+		buf := make([]byte, dnsMaxMessageSize)
+		for {
+			n, addr, err := upstream.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var q dnsmessage.Message
+			if err := q.Unpack(buf[:n]); err != nil {
+				continue
+			}
+			q.Response = true
+			q.Answers = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: q.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+				Body:   &dnsmessage.AResource{A: [4]byte{192, 0, 2, 53}},
+			}}
+			resp, _ := q.Pack()
+			upstream.WriteTo(resp, addr)
+		}
+	}()
+
+	app := &Application{}
+	records := []dnsRecord{{pattern: "app.phantom.test", ip: net.IPv4(127, 0, 0, 2)}}
+	app.dnsRecords.Store(&records)
+
+	s := &dnsServer{
+		logger:   zap.NewNop(),
+		conn:     listenUDP(t),
+		zone:     "phantom.test",
+		upstream: upstream.LocalAddr().String(),
+		lookup:   app.lookupForwarder,
+		inflight: make(map[dnsQuery]bool),
+	}
+	go s.serve()
+
+	cases := []struct {
+		name     string
+		rcode    dnsmessage.RCode
+		expected []byte
+	}{
+		{
+			name:     "app.phantom.test.",
+			rcode:    dnsmessage.RCodeSuccess,
+			expected: []byte{127, 0, 0, 2},
+		},
+		{
+			name:  "missing.phantom.test.",
+			rcode: dnsmessage.RCodeNameError,
+		},
+		{
+			name:     "example.com.",
+			rcode:    dnsmessage.RCodeSuccess,
+			expected: []byte{192, 0, 2, 53},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := exchange(t, s.conn.LocalAddr(), buildQuery(t, uint16(i+1), tc.name))
+			if resp.RCode != tc.rcode {
+				t.Fatalf("expected rcode %s, got %s", tc.rcode, resp.RCode)
+			}
+			if tc.expected == nil {
+				if len(resp.Answers) != 0 {
+					t.Fatalf("expected no answers, got %d", len(resp.Answers))
+				}
+				return
+			}
+			if len(resp.Answers) != 1 {
+				t.Fatalf("expected one answer, got %d", len(resp.Answers))
+			}
+			a, ok := resp.Answers[0].Body.(*dnsmessage.AResource)
+			if !ok || net.IP(a.A[:]).String() != net.IP(tc.expected).String() {
+				t.Fatalf("expected %v, got %v", net.IP(tc.expected), resp.Answers[0].Body)
+			}
+		})
+	}
+
+	// a query arriving again while it is being relayed is dropped
+	key := dnsQuery{id: 42, name: "example.com", qtype: dnsmessage.TypeA}
+	if !s.begin(key) {
+		t.Fatal("expected the first relay to start")
+	}
+	if s.begin(key) {
+		t.Fatal("expected the looped query to be dropped")
+	}
+	s.end(key)
+	if !s.begin(key) {
+		t.Fatal("expected the query to be relayed again once done")
+	}
+}
+
+func TestDNSLocalNames(t *testing.T) {
+	app := &Application{
+		phantomCfg: &PhantomConfig{
+			Listeners: []Listener{
+				{
+					Listen:   "127.0.0.1:8080",
+					Hostname: "app.example.com",
+					HTTPRoutes: []HTTPRoute{
+						{Host: "App.Local", Hostname: "app.example.com"},
+						{Host: "api.example.com", Hostname: "app.example.com"},
+						{Host: "admin.phantom.test", Hostname: "app.example.com"},
+					},
+				},
+				{
+					Listen:   "127.0.0.1:8081",
+					Hostname: "api.example.com",
+				},
+			},
+		},
+	}
+	tunnels := app.tunnelHosts()
+
+	names := app.phantomCfg.Listeners[0].localNames("phantom.test", tunnels)
+	expected := []string{"app.phantom.test", "app.local", "admin.phantom.test"}
+	if len(names) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, names)
+		}
+	}
+
+	// only the zone and local aliases are routed, never the domain of a tunnel
+	domains := app.dnsDomains("phantom.test")
+	expected = []string{"phantom.test", "app.local"}
+	if len(domains) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, domains)
+	}
+	for i := range expected {
+		if domains[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, domains)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package phantom
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"kon.nect.sh/phantom/internal/schedule"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"go.uber.org/zap"
+)
+
+const drainPollInterval = time.Millisecond * 250
+
+// connTracker keeps track of the local connections of a forwarder that are
+// still being proxied, so they can be drained or force closed on stop.
+// Forwarders proxying HTTP count requests in flight as activity instead, so
+// that idle keep-alive connections do not keep them from draining or idling.
+type connTracker struct {
+	mu         sync.Mutex
+	conns      map[net.Conn]struct{}
+	perRequest bool
+	requests   int
+	lastActive time.Time
+	clock      schedule.Clock
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{
+		conns:      make(map[net.Conn]struct{}),
+		lastActive: schedule.System.Now(),
+		clock:      schedule.System,
+	}
+}
+
+func (t *connTracker) track(conn net.Conn) net.Conn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.conns[conn] = struct{}{}
+	t.lastActive = t.clock.Now()
+
+	return &trackedConn{
+		Conn: conn,
+		onClose: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+
+			delete(t.conns, conn)
+			t.lastActive = t.clock.Now()
+		},
+	}
+}
+
+func (t *connTracker) beginRequest() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.requests++
+	t.lastActive = t.clock.Now()
+}
+
+func (t *connTracker) endRequest() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.requests--
+	t.lastActive = t.clock.Now()
+}
+
+func (t *connTracker) active() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.activeLocked()
+}
+
+// t.mu must be held
+func (t *connTracker) activeLocked() int {
+	if t.perRequest {
+		return t.requests
+	}
+	return len(t.conns)
+}
+
+// idle returns how long the forwarder has gone without active connections
+func (t *connTracker) idle() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.activeLocked() > 0 {
+		return 0
+	}
+	return t.clock.Now().Sub(t.lastActive)
+}
+
+func (t *connTracker) closeAll() int {
+	t.mu.Lock()
+	conns := make([]net.Conn, 0, len(t.conns))
+	for c := range t.conns {
+		conns = append(conns, c)
+	}
+	t.mu.Unlock()
+
+	for _, c := range conns {
+		c.Close()
+	}
+	return len(conns)
+}
+
+// wait returns false if there are still active connections after timeout
+func (t *connTracker) wait(timeout time.Duration) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for t.active() > 0 {
+		select {
+		case <-deadline.C:
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
+
+type trackingListener struct {
+	net.Listener
+	tracker *connTracker
+}
+
+var _ net.Listener = (*trackingListener)(nil)
+
+func (l *trackingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.tracker.track(conn), nil
+}
+
+// drain stops accepting new connections right away, then gives the active ones
+// until timeout to finish before they are closed forcibly.
+func (app *Application) drainForwarder(logger *zap.Logger, f *forwarder, timeout time.Duration) {
+	defer app.draining.Done()
+
+	f.listener.Close()
+	if r, ok := f.router.(*httpRouter); ok {
+		// closes idle keep-alive connections, and the others once their request is done
+		r.server.SetKeepAlivesEnabled(false)
+	}
+
+	if active := f.conns.active(); active > 0 && timeout > 0 {
+		logger.Info("Draining forwarder", zap.Int("active", active), zap.Duration("timeout", timeout))
+		runtime.EventsEmit(app.appCtx, "forwarder:Draining", f.cfg.Listen, active)
+
+		if !f.conns.wait(timeout) {
+			logger.Warn("Drain timed out, closing remaining connections", zap.Int("active", f.conns.active()))
+		}
+	}
+
+	if closed := f.conns.closeAll(); closed > 0 {
+		logger.Info("Closed active connections", zap.Int("closed", closed))
+	}
+	f.stop()
+
+	runtime.EventsEmit(app.appCtx, "forwarder:Drained", f.cfg.Listen)
+}
+
+func (app *Application) drainTimeout() time.Duration {
+	return time.Duration(app.phantomCfg.DrainTimeout) * time.Second
+}
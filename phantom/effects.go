@@ -0,0 +1,91 @@
+package phantom
+
+import (
+	"sync"
+)
+
+// effectQueue runs work that takes app.stateMu on its own, such as emitting group
+// status or rewriting the hosts file, on a single goroutine in the order it was
+// queued. Code holding the lock can queue work without blocking on it.
+type effectQueue struct {
+	mu      sync.Mutex
+	pending []func()
+	wake    chan struct{}
+	done    chan struct{}
+	closed  bool
+}
+
+func newEffectQueue() *effectQueue {
+	q := &effectQueue{
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+func (q *effectQueue) push(fn func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+	q.pending = append(q.pending, fn)
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *effectQueue) run() {
+	defer close(q.done)
+
+	for range q.wake {
+		for {
+			q.mu.Lock()
+			if len(q.pending) == 0 {
+				q.mu.Unlock()
+				break
+			}
+			fn := q.pending[0]
+			q.pending = q.pending[1:]
+			q.mu.Unlock()
+
+			fn()
+		}
+	}
+}
+
+// close runs the work queued so far and stops the queue, later work is dropped
+func (q *effectQueue) close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	close(q.wake)
+	q.mu.Unlock()
+
+	<-q.done
+}
+
+// forwarderChanged queues the updates that follow a forwarder of group starting or stopping
+func (app *Application) forwarderChanged(group string) {
+	app.effects.push(func() {
+		app.stateMu.RLock()
+		app.refreshDNSRecords(app.phantomCfg.DNS.zone())
+		app.stateMu.RUnlock()
+
+		app.emitGroupStatus(group)
+		app.syncHostsFile()
+		app.renderTemplates(group)
+	})
+}
+
+func (app *Application) queueGroupStatus(group string) {
+	app.effects.push(func() {
+		app.emitGroupStatus(group)
+	})
+}
@@ -0,0 +1,318 @@
+package phantom
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"kon.nect.sh/specter/tun/client/dialer"
+
+	"go.uber.org/zap"
+)
+
+const (
+	StrategyPriority   = "priority"
+	StrategyRoundRobin = "roundRobin"
+	StrategyLowestRTT  = "lowestRTT"
+)
+
+// rttSmoothing is the weight of the latest sample in the moving average
+const rttSmoothing = 0.3
+
+// hostnames returns the primary hostname followed by the additional ones and
+// the http routes, without duplicates
+func (l *Listener) hostnames() []string {
+	seen := make(map[string]bool)
+	candidates := append([]string{l.Hostname}, l.Hostnames...)
+	if l.Mode == ListenerModeHTTP {
+		for _, r := range l.HTTPRoutes {
+			candidates = append(candidates, r.Hostname)
+		}
+	}
+	hostnames := make([]string, 0, len(candidates))
+	for _, h := range candidates {
+		h = strings.TrimSpace(h)
+		key := strings.ToLower(h)
+		if h == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		hostnames = append(hostnames, h)
+	}
+	return hostnames
+}
+
+type RouteStatus struct {
+	Hostname  string       `json:"hostname"`
+	Status    HealthStatus `json:"status"`
+	LastError string       `json:"lastError,omitempty"`
+	Transport string       `json:"transport,omitempty"`
+	Via       string       `json:"via"`
+	RTT       int64        `json:"rtt"` // milliseconds
+	Active    int64        `json:"active"`
+	Served    uint64       `json:"served"`
+}
+
+// route is one of the tunnel hostnames a forwarder can reach the target through
+type route struct {
+	hostname string
+	dialer   *managedDialer
+	rtt      atomic.Int64
+	served   atomic.Uint64
+
+	mu        sync.RWMutex
+	status    HealthStatus
+	lastError string
+
+	// owned by the monitor goroutine
+	failures int
+	wait     backoff
+	due      time.Time
+}
+
+func newRoute(hostname string, d *managedDialer) *route {
+	status := HealthHealthy
+	if d.lazy {
+		status = HealthIdle
+	}
+	return &route{
+		hostname: hostname,
+		dialer:   d,
+		status:   status,
+		wait:     backoff{min: redialMinBackoff, max: redialMaxBackoff},
+		due:      time.Now().Add(healthCheckInterval),
+	}
+}
+
+func (r *route) setStatus(status HealthStatus, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.status = status
+	if err != nil {
+		r.lastError = err.Error()
+	} else {
+		r.lastError = ""
+	}
+}
+
+func (r *route) getStatus() (HealthStatus, string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.status, r.lastError
+}
+
+func (r *route) usable() bool {
+	status, _ := r.getStatus()
+	return status != HealthFailed
+}
+
+func (r *route) observe(d time.Duration) {
+	prev := r.rtt.Load()
+	if prev == 0 {
+		r.rtt.Store(int64(d))
+		return
+	}
+	r.rtt.Store(int64(rttSmoothing*float64(d) + (1-rttSmoothing)*float64(prev)))
+}
+
+func (r *route) snapshot() RouteStatus {
+	status, lastError := r.getStatus()
+	return RouteStatus{
+		Hostname:  r.hostname,
+		Status:    status,
+		LastError: lastError,
+		Transport: r.dialer.transport(),
+		Via:       r.dialer.Remote().String(),
+		RTT:       time.Duration(r.rtt.Load()).Milliseconds(),
+		Active:    r.dialer.active.Load(),
+		Served:    r.served.Load(),
+	}
+}
+
+// routeSet spreads the connections of a forwarder across its routes according
+// to the strategy, and fails over to the next route when a dial fails.
+type routeSet struct {
+	logger   *zap.Logger
+	strategy string
+	routes   []*route
+	next     atomic.Uint32
+	last     atomic.Pointer[route]
+}
+
+var _ dialer.TransportDialer = (*routeSet)(nil)
+
+func (rs *routeSet) order() []*route {
+	ordered := make([]*route, len(rs.routes))
+	switch rs.strategy {
+	case StrategyRoundRobin:
+		start := int(rs.next.Add(1)-1) % len(rs.routes)
+		for i := range rs.routes {
+			ordered[i] = rs.routes[(start+i)%len(rs.routes)]
+		}
+	case StrategyLowestRTT:
+		copy(ordered, rs.routes)
+		// routes without measurement sort first so they get measured
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return ordered[i].rtt.Load() < ordered[j].rtt.Load()
+		})
+	default:
+		copy(ordered, rs.routes)
+	}
+
+	// failed routes are only tried as a last resort
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].usable() && !ordered[j].usable()
+	})
+	return ordered
+}
+
+func (r *route) dial(logger *zap.Logger) (net.Conn, error) {
+	start := time.Now()
+	conn, err := r.dialer.Dial()
+	if err != nil {
+		logger.Warn("Failed to dial route", zap.String("hostname", r.hostname), zap.Error(err))
+		r.setStatus(HealthFailed, err)
+		return nil, err
+	}
+	r.observe(time.Since(start))
+	r.served.Add(1)
+	return conn, nil
+}
+
+func (rs *routeSet) Dial() (net.Conn, error) {
+	var errs []error
+	for _, r := range rs.order() {
+		conn, err := r.dial(rs.logger)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r.hostname, err))
+			continue
+		}
+		rs.last.Store(r)
+		rs.logger.Debug("Connection routed", zap.String("hostname", r.hostname))
+		return conn, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// current may return nil when routes are only created on demand, see sniRouter
+func (rs *routeSet) current() *route {
+	if r := rs.last.Load(); r != nil {
+		return r
+	}
+	if len(rs.routes) == 0 {
+		return nil
+	}
+	return rs.routes[0]
+}
+
+func (rs *routeSet) Remote() net.Addr {
+	r := rs.current()
+	if r == nil {
+		return &net.TCPAddr{}
+	}
+	return r.dialer.Remote()
+}
+
+func (rs *routeSet) transport() string {
+	r := rs.current()
+	if r == nil {
+		return ""
+	}
+	return r.dialer.transport()
+}
+
+// connect establishes the gateway connection of every route, and only fails if none of them can be reached
+func (rs *routeSet) connect() error {
+	var errs []error
+	for _, r := range rs.routes {
+		if err := r.dialer.redial(); err != nil {
+			rs.logger.Warn("Failed to dial route", zap.String("hostname", r.hostname), zap.Error(err))
+			r.setStatus(HealthFailed, err)
+			r.failures = healthFailThreshold
+			r.due = time.Now().Add(r.wait.next())
+			errs = append(errs, fmt.Errorf("%s: %w", r.hostname, err))
+		}
+	}
+	if len(errs) == len(rs.routes) {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+func (rs *routeSet) nextCheck() time.Duration {
+	if len(rs.routes) == 0 {
+		return healthCheckInterval
+	}
+	due := rs.routes[0].due
+	for _, r := range rs.routes[1:] {
+		if r.due.Before(due) {
+			due = r.due
+		}
+	}
+	if d := time.Until(due); d > 0 {
+		return d
+	}
+	return 0
+}
+
+func (rs *routeSet) close() {
+	for _, r := range rs.routes {
+		r.dialer.close()
+	}
+}
+
+func (rs *routeSet) snapshot() []RouteStatus {
+	routes := make([]RouteStatus, len(rs.routes))
+	for i, r := range rs.routes {
+		routes[i] = r.snapshot()
+	}
+	return routes
+}
+
+// aggregate folds the status of all routes into the status of the forwarder
+func (rs *routeSet) aggregate() (HealthStatus, error) {
+	var (
+		idle    = 0
+		failed  = 0
+		errs    []error
+		healthy = true
+	)
+	for _, r := range rs.routes {
+		status, lastError := r.getStatus()
+		switch status {
+		case HealthIdle:
+			idle++
+		case HealthFailed:
+			failed++
+		}
+		if status == HealthFailed || status == HealthDegraded {
+			healthy = false
+		}
+		if lastError != "" {
+			errs = append(errs, fmt.Errorf("%s: %s", r.hostname, lastError))
+		}
+	}
+
+	var err error
+	if len(errs) > 0 {
+		err = errors.Join(errs...)
+	}
+
+	switch {
+	case idle == len(rs.routes):
+		return HealthIdle, nil
+	case failed == len(rs.routes):
+		return HealthFailed, err
+	case healthy:
+		return HealthHealthy, nil
+	default:
+		return HealthDegraded, err
+	}
+}
@@ -4,6 +4,10 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 	"kon.nect.sh/specter/tun/client/connector"
@@ -15,11 +19,34 @@ import (
 )
 
 type Listener struct {
-	Label    string `json:"label"`
-	Listen   string `json:"listen"`
-	Hostname string `json:"hostname"`
-	Insecure bool   `json:"insecure"`
-	UseTCP   bool   `json:"tcp"`
+	Label                string      `json:"label"`
+	Listen               string      `json:"listen"`
+	Mode                 string      `json:"mode,omitempty"` // empty to forward, sni to route by TLS server name, or http to route by Host header
+	HTTPRoutes           []HTTPRoute `json:"httpRoutes,omitempty"`
+	Hostname             string      `json:"hostname"`
+	Hostnames            []string    `json:"hostnames,omitempty"` // additional hostnames to fail over or balance to, or to route in sni mode
+	Strategy             string      `json:"strategy,omitempty"`  // priority, roundRobin or lowestRTT
+	Insecure             bool        `json:"insecure"`
+	UseTCP               bool        `json:"tcp"`
+	Transport            string      `json:"transport,omitempty"` // quic, tcp or auto; overrides tcp
+	Allow                []string    `json:"allow,omitempty"`
+	Deny                 []string    `json:"deny,omitempty"`
+	MaxConnections       int         `json:"maxConnections,omitempty"`
+	ConnectionsPerSecond int         `json:"connectionsPerSecond,omitempty"`
+	QueueExcess          bool        `json:"queueExcess,omitempty"`
+	UploadLimit          int64       `json:"uploadLimit,omitempty"`   // bytes per second
+	DownloadLimit        int64       `json:"downloadLimit,omitempty"` // bytes per second
+	Lazy                 bool        `json:"lazy,omitempty"`
+	LazyIdleTimeout      int         `json:"lazyIdleTimeout,omitempty"` // seconds
+	PersistPort          bool        `json:"persistPort,omitempty"`
+	Group                string      `json:"group,omitempty"`
+	Autostart            bool        `json:"autostart,omitempty"`
+	TerminateTLS         bool        `json:"terminateTLS,omitempty"`
+	TLSNames             []string    `json:"tlsNames,omitempty"`    // names of the local certificate, defaults to localhost and the listen host
+	HostAliases          []string    `json:"hostAliases,omitempty"` // names mapped to the forwarder in the managed hosts file block
+	Mirrored             bool        `json:"mirrored,omitempty"`    // managed by mirror mode
+	Schedule             string      `json:"schedule,omitempty"`    // cron-like window to run in, such as "* 9-17 * * mon-fri"
+	IdleTimeout          int         `json:"idleTimeout,omitempty"` // minutes without connections before the forwarder stops
 }
 
 var _ zapcore.ObjectMarshaler = (*Listener)(nil)
@@ -27,74 +54,115 @@ var _ zapcore.ObjectMarshaler = (*Listener)(nil)
 func (l *Listener) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	enc.AddString("listen", l.Listen)
 	enc.AddString("via", l.Hostname)
+	if l.Mode != ListenerModeForward {
+		enc.AddString("mode", l.Mode)
+	}
+	if len(l.Hostnames) > 0 {
+		enc.AddString("also", strings.Join(l.Hostnames, ","))
+		enc.AddString("strategy", l.Strategy)
+	}
 	enc.AddBool("insecure", l.Insecure)
-	enc.AddBool("tcp", l.UseTCP)
+	enc.AddString("transport", l.transport())
+	enc.AddBool("lazy", l.Lazy)
+	if l.TerminateTLS {
+		enc.AddBool("terminateTLS", true)
+	}
+	if l.Group != "" {
+		enc.AddString("group", l.Group)
+	}
+	if len(l.Allow) > 0 || len(l.Deny) > 0 {
+		enc.AddInt("allowRules", len(l.Allow))
+		enc.AddInt("denyRules", len(l.Deny))
+	}
+	if l.MaxConnections > 0 {
+		enc.AddInt("maxConnections", l.MaxConnections)
+	}
+	if l.ConnectionsPerSecond > 0 {
+		enc.AddInt("connectionsPerSecond", l.ConnectionsPerSecond)
+	}
 	return nil
 }
 
 type forwarder struct {
-	ctx      context.Context
-	cancel   context.CancelFunc
-	listener net.Listener
-	dialer   dialer.TransportDialer
-	cfg      Listener
+	ctx         context.Context
+	cancel      context.CancelFunc
+	monitorCtx  context.Context
+	stopMonitor context.CancelFunc // called once draining starts, ending the health monitor and idle watcher
+	listener    net.Listener
+	bound       string
+	conns       *connTracker
+	dialer      *routeSet
+	router      connHandler
+	cfg         Listener
+	rejected    atomic.Uint64
+	limited     atomic.Uint64
+	idleTimeout atomic.Int64 // time.Duration, 0 never stops the forwarder
+
+	statusMu sync.RWMutex
+	status   ForwarderStatus
+	group    string // read from the monitor goroutine, which cannot use cfg
 }
 
 func (f *forwarder) stop() {
 	f.listener.Close()
 	f.cancel()
+	f.dialer.close()
+	if f.router != nil {
+		f.router.close()
+	}
+}
+
+func (app *Application) StartAllForwarders() ([]ForwarderResult, error) {
+	return app.startAllForwarders(false)
 }
 
-func (app *Application) StartAllForwarders() error {
+// StartAllForwardersTransactional starts every configured forwarder, or none of them
+func (app *Application) StartAllForwardersTransactional() ([]ForwarderResult, error) {
+	return app.startAllForwarders(true)
+}
+
+func (app *Application) startAllForwarders(transactional bool) ([]ForwarderResult, error) {
 	app.stateMu.Lock()
 	defer app.stateMu.Unlock()
 
-	toStart := make([]Listener, 0)
-	for _, l := range app.phantomCfg.Listeners {
-		if _, ok := app.forwarders.Load(l.Listen); ok {
-			continue
-		}
-		toStart = append(toStart, l)
-	}
-
-	if len(toStart) == 0 {
-		return nil
+	listeners := app.phantomCfg.Listeners
+	if app.isAllForwardersStarted() || len(listeners) == 0 {
+		return []ForwarderResult{}, nil
 	}
 
 	runtime.EventsEmit(app.appCtx, "forwarders:Starting")
 
-	startJobs := make([]func(context.Context) (int, error), len(toStart))
+	results, err := app.startBatch(listeners, transactional)
+	app.emitBatchResult(results)
+
+	return results, err
+}
+
+// app.stateMu must be held
+func (app *Application) startListeners(toStart []Listener) ([]*forwarder, []error) {
+	startJobs := make([]func(context.Context) (*forwarder, error), len(toStart))
 	for i, l := range toStart {
 		l := l
-		startJobs[i] = func(ctx context.Context) (int, error) {
-			err := app.startForwarder(l)
+		startJobs[i] = func(ctx context.Context) (*forwarder, error) {
+			f, err := app.startForwarder(l)
 			if err != nil {
 				app.logger.Error("Failed to start forwarder", zap.Object("listener", &l), zap.Error(err))
 			}
-			return 0, err
+			return f, err
 		}
 	}
 
-	var (
-		hasError = false
-		errIndex int
-	)
-	_, errors := promise.All(app.appCtx, startJobs...)
-	for i, err := range errors {
-		if err != nil {
-			errIndex = i
-			hasError = true
-			break
+	started, errors := promise.All(app.appCtx, startJobs...)
+	for i, f := range started {
+		if f == nil {
+			continue
+		}
+		if err := app.persistBoundAddress(toStart[i].Listen, f); err != nil {
+			app.logger.Error("Failed to persist bound address", zap.Object("listener", &f.cfg), zap.Error(err))
 		}
 	}
 
-	if hasError {
-		runtime.EventsEmit(app.appCtx, "forwarders:Stopped")
-		return errors[errIndex]
-	} else {
-		runtime.EventsEmit(app.appCtx, "forwarders:Started")
-		return nil
-	}
+	return started, errors
 }
 
 func (app *Application) StopAllForwarders() {
@@ -103,6 +171,7 @@ func (app *Application) StopAllForwarders() {
 	app.stateMu.Lock()
 	defer app.stateMu.Unlock()
 
+	app.cancelAllRetries()
 	app.forwarders.Range(func(listen string, f *forwarder) bool {
 		app.stopForwarder(f.cfg, f)
 		runtime.EventsEmit(app.appCtx, "forwarder:Stopped", f.cfg.Listen)
@@ -110,19 +179,103 @@ func (app *Application) StopAllForwarders() {
 	})
 }
 
-func (app *Application) getNewForwarder(l Listener) (*forwarder, error) {
-	listener, err := net.Listen("tcp", l.Listen)
+func (app *Application) getNewForwarder(logger *zap.Logger, l Listener, acl *accessList) (*forwarder, error) {
+	listener, err := listenLocal(l.Listen)
+	rebound := false
+	if err != nil && l.Mirrored {
+		// the port suggested for a mirrored forwarder may have been taken before it was bound, any other will do
+		if host, _, splitErr := net.SplitHostPort(l.Listen); splitErr == nil {
+			listener, err = listenLocal(net.JoinHostPort(host, "0"))
+			rebound = err == nil
+		}
+	}
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("error listening locally: %w", err)
+	}
+
+	bound := listener.Addr().String()
+	if rebound || (l.PersistPort && isEphemeralListen(l.Listen)) {
+		l.Listen = bound
 	}
 
 	forwardCtx, forwardCancel := context.WithCancel(app.appCtx)
-	return &forwarder{
-		ctx:      forwardCtx,
-		cancel:   forwardCancel,
-		listener: listener,
-		cfg:      l,
-	}, nil
+	monitorCtx, stopMonitor := context.WithCancel(forwardCtx)
+	f := &forwarder{
+		ctx:         forwardCtx,
+		cancel:      forwardCancel,
+		monitorCtx:  monitorCtx,
+		stopMonitor: stopMonitor,
+		bound:       bound,
+		conns:       newConnTracker(),
+		cfg:         l,
+		group:       l.Group,
+		status: ForwarderStatus{
+			Listen:  l.Listen,
+			Address: bound,
+		},
+	}
+	f.dialer = &routeSet{
+		logger:   logger,
+		strategy: l.Strategy,
+		routes:   make([]*route, 0),
+	}
+	gatewayDial := func(hostname string) gatewayDialFunc {
+		target := l
+		target.Hostname = hostname
+		return func(ctx context.Context) (gatewayConn, error) {
+			return app.gateways.acquire(ctx, target)
+		}
+	}
+	lazyIdle := time.Duration(l.LazyIdleTimeout) * time.Second
+	for _, hostname := range l.hostnames() {
+		if isWildcardHostname(hostname) {
+			continue
+		}
+		var d *managedDialer
+		if l.Lazy {
+			d = newLazyDialer(forwardCtx, gatewayDial(hostname), lazyIdle, nil)
+		} else {
+			d = newManagedDialer(forwardCtx, gatewayDial(hostname))
+		}
+		f.dialer.routes = append(f.dialer.routes, newRoute(hostname, d))
+	}
+	switch l.Mode {
+	case ListenerModeSNI:
+		f.router = newSNIRouter(forwardCtx, logger, l, f.dialer.routes, func(ctx context.Context, hostname string, onReap func()) *route {
+			return newRoute(hostname, newLazyDialer(ctx, gatewayDial(hostname), lazyIdle, onReap))
+		})
+	case ListenerModeHTTP:
+		f.router = newHTTPRouter(logger, l, f.dialer.routes, f.conns)
+	}
+	f.listener = &filteredListener{
+		Listener: listener,
+		logger:   logger,
+		acl:      acl,
+		rejected: &f.rejected,
+	}
+	if limits := newConnectionLimits(l); limits.configured() {
+		f.listener = &limitedListener{
+			Listener: f.listener,
+			ctx:      forwardCtx,
+			logger:   logger,
+			limits:   limits,
+			limited:  &f.limited,
+		}
+	}
+	f.listener = &trackingListener{
+		Listener: f.listener,
+		tracker:  f.conns,
+	}
+	if l.TerminateTLS {
+		tlsListener, err := app.terminateTLS(l, f.listener)
+		if err != nil {
+			listener.Close()
+			forwardCancel()
+			return nil, fmt.Errorf("error terminating TLS locally: %w", err)
+		}
+		f.listener = tlsListener
+	}
+	return f, nil
 }
 
 // app.stateMu must be held
@@ -163,83 +316,126 @@ func (app *Application) AddForwarder(l Listener) error {
 	app.stateMu.Lock()
 	defer app.stateMu.Unlock()
 
-	if _, ok := app.forwarders.Load(l.Listen); ok {
+	if _, ok := app.forwarders.Load(l.Listen); ok || app.isListenConfigured(l.Listen) {
 		return fmt.Errorf("listener with address %s already exists", l.Listen)
 	}
-
-	err := app.startForwarder(l)
-	if err != nil {
+	if err := validSchedule(l.Schedule); err != nil {
 		return err
 	}
 
+	// outside of its schedule, the forwarder is left for the scheduler to start once the window opens
+	started := scheduleActive(l.Schedule, time.Now())
+	if started {
+		f, err := app.startForwarder(l)
+		if err != nil {
+			return err
+		}
+		l = f.cfg
+	}
+
 	app.phantomCfg.Listeners = append(app.phantomCfg.Listeners, l)
 
 	if err := app.persistPhantomConfig(app.phantomCfg); err != nil {
 		return fmt.Errorf("failed to persist forwarder config: %w", err)
 	}
 
-	if app.isAllForwardersStarted() {
+	if started && app.isAllForwardersStarted() {
 		runtime.EventsEmit(app.appCtx, "forwarders:Started")
 	}
 
 	return nil
 }
 
-func (app *Application) startForwarder(l Listener) error {
-	var (
-		logger = app.logger.With(zap.Object("listener", &l))
-		remote net.Addr
-		dial   dialer.TransportDialer
-		err    error
-	)
+func (app *Application) startForwarder(l Listener) (*forwarder, error) {
+	logger := app.logger.With(zap.Object("listener", &l))
 
-	parsed, err := dialer.ParseApex(l.Hostname)
-	if err != nil {
-		return fmt.Errorf("error parsing hostname: %w", err)
+	hostnames := l.hostnames()
+	if len(hostnames) == 0 {
+		return nil, fmt.Errorf("hostname cannot be empty")
+	}
+	for _, hostname := range hostnames {
+		if isWildcardHostname(hostname) {
+			if l.Mode != ListenerModeSNI {
+				return nil, fmt.Errorf("wildcard hostname %s is only supported in sni mode", hostname)
+			}
+			hostname = strings.TrimPrefix(hostname, "*.")
+		}
+		if _, err := dialer.ParseApex(hostname); err != nil {
+			return nil, fmt.Errorf("error parsing hostname %s: %w", hostname, err)
+		}
 	}
 
-	app.logger.Info("Starting forwarder", zap.Object("listener", &l))
+	switch l.Mode {
+	case ListenerModeForward:
+	case ListenerModeSNI:
+		if l.TerminateTLS {
+			return nil, fmt.Errorf("sni mode passes TLS through and cannot terminate it")
+		}
+	case ListenerModeHTTP:
+		for _, r := range l.HTTPRoutes {
+			if strings.TrimSpace(r.Host) == "" || strings.TrimSpace(r.Hostname) == "" {
+				return nil, fmt.Errorf("http route needs both a local host and a tunnel hostname")
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown listener mode %q", l.Mode)
+	}
 
-	f, err := app.getNewForwarder(l)
+	acl, err := newAccessList(l)
 	if err != nil {
-		return fmt.Errorf("error listening locally: %w", err)
+		return nil, err
 	}
 
-	if l.UseTCP {
-		remote, dial, err = dialer.TLSDialer(f.ctx, dialer.DialerConfig{
-			Logger:             logger,
-			Parsed:             parsed,
-			InsecureSkipVerify: l.Insecure,
-			NoReconnection:     false,
-		})
-	} else {
-		remote, dial, err = dialer.QuicDialer(f.ctx, dialer.DialerConfig{
-			Logger:             logger,
-			Parsed:             parsed,
-			InsecureSkipVerify: l.Insecure,
-			NoReconnection:     false,
-		})
+	if err := checkPublicListen(app.phantomCfg.RestrictPublicListen, l.Listen, acl); err != nil {
+		return nil, err
 	}
+
+	app.logger.Info("Starting forwarder", zap.Object("listener", &l))
+
+	f, err := app.getNewForwarder(logger, l, acl)
 	if err != nil {
-		return fmt.Errorf("error dialing specter gateway: %w", err)
+		return nil, err
 	}
 
-	logger.Info("Listening for local connections", zap.String("listen", f.listener.Addr().String()), zap.String("via", remote.String()))
-
-	go connector.HandleConnections(logger, f.listener, dial)
+	if !l.Lazy {
+		if err := f.dialer.connect(); err != nil {
+			f.stop()
+			return nil, fmt.Errorf("error dialing specter gateway: %w", err)
+		}
+	}
 
-	f.dialer = dial
-	app.forwarders.Store(l.Listen, f)
-	runtime.EventsEmit(app.appCtx, "forwarder:Started", l.Listen)
+	logger.Info("Listening for local connections", zap.String("listen", f.bound), zap.String("via", f.dialer.Remote().String()))
 
-	return nil
+	if f.router != nil {
+		go f.router.serve(f.listener)
+	} else {
+		go connector.HandleConnections(logger, f.listener, f.dialer)
+	}
+	go app.monitorForwarder(logger, f)
+	f.idleTimeout.Store(int64(time.Duration(l.IdleTimeout) * time.Minute))
+	go app.watchIdle(logger, f, l.Listen)
+
+	app.forwarders.Store(f.cfg.Listen, f)
+	runtime.EventsEmit(app.appCtx, "forwarder:Started", f.cfg.Listen)
+	runtime.EventsEmit(app.appCtx, "forwarder:Bound", f.cfg.Listen, f.bound)
+	status, statusErr := f.dialer.aggregate()
+	app.updateForwarderStatus(f, status, statusErr)
+	app.forwarderChanged(f.cfg.Group)
+
+	return f, nil
 }
 
 func (app *Application) stopForwarder(l Listener, f *forwarder) {
-	app.logger.Info("Stopping forwarder", zap.Object("listener", &l))
+	logger := app.logger.With(zap.Object("listener", &l))
+	logger.Info("Stopping forwarder")
 
-	f.stop()
+	// refuse new connections right away, and let the active ones finish in the background
+	f.stopMonitor()
+	f.listener.Close()
 	app.forwarders.Delete(l.Listen)
+	app.draining.Add(1)
+	go app.drainForwarder(logger, f, app.drainTimeout())
+	app.forwarderChanged(l.Group)
 }
 
 func (app *Application) findForwarder(index int) (l Listener, f *forwarder, ok bool, err error) {
@@ -276,6 +472,31 @@ func (app *Application) UpdateForwaderLabel(index int, label string) error {
 	return nil
 }
 
+func (app *Application) UpdateForwarderIdleTimeout(index int, minutes int) error {
+	app.stateMu.Lock()
+	defer app.stateMu.Unlock()
+
+	_, f, ok, err := app.findForwarder(index)
+	if err != nil {
+		return err
+	}
+	if minutes < 0 {
+		return fmt.Errorf("idle timeout cannot be negative")
+	}
+
+	app.phantomCfg.Listeners[index].IdleTimeout = minutes
+	if ok {
+		f.cfg = app.phantomCfg.Listeners[index]
+		f.idleTimeout.Store(int64(time.Duration(minutes) * time.Minute))
+	}
+
+	if err := app.persistPhantomConfig(app.phantomCfg); err != nil {
+		return fmt.Errorf("failed to persist forwarder config: %w", err)
+	}
+
+	return nil
+}
+
 func (app *Application) RemoveForwarder(index int) error {
 	app.stateMu.Lock()
 	defer app.stateMu.Unlock()
@@ -286,6 +507,7 @@ func (app *Application) RemoveForwarder(index int) error {
 	}
 
 	app.logger.Info("Removing forwarder", zap.Object("listener", &l))
+	app.cancelRetry(l.Listen)
 	if ok {
 		app.stopForwarder(l, f)
 	}
@@ -313,6 +535,7 @@ func (app *Application) StopForwarder(index int) error {
 		return err
 	}
 
+	app.cancelRetry(l.Listen)
 	if !ok {
 		return nil
 	}
@@ -338,10 +561,15 @@ func (app *Application) StartForwarder(index int) error {
 		return err
 	}
 
-	err = app.startForwarder(l)
+	f, err := app.startForwarder(l)
 	if err != nil {
 		return err
 	}
+	app.cancelRetry(l.Listen)
+
+	if err := app.persistBoundAddress(l.Listen, f); err != nil {
+		return fmt.Errorf("failed to persist forwarder config: %w", err)
+	}
 
 	if app.isAllForwardersStarted() {
 		runtime.EventsEmit(app.appCtx, "forwarders:Started")
@@ -351,8 +579,14 @@ func (app *Application) StartForwarder(index int) error {
 }
 
 type ForwarderNode struct {
-	Label string `json:"label"`
-	Via   string `json:"via"`
+	Label    string       `json:"label"`
+	Listen   string       `json:"listen"`
+	Address  string       `json:"address"`
+	Via      string       `json:"via"`
+	Active   int          `json:"active"`
+	Rejected uint64       `json:"rejected"`
+	Limited  uint64       `json:"limited"`
+	Status   HealthStatus `json:"status"`
 }
 
 func (app *Application) GetConnectedForwarderNodes() []ForwarderNode {
@@ -362,8 +596,14 @@ func (app *Application) GetConnectedForwarderNodes() []ForwarderNode {
 	nodes := make([]ForwarderNode, 0)
 	app.forwarders.Range(func(listen string, f *forwarder) bool {
 		nodes = append(nodes, ForwarderNode{
-			Label: f.cfg.Label,
-			Via:   f.dialer.Remote().String(),
+			Label:    f.cfg.Label,
+			Listen:   f.cfg.Listen,
+			Address:  f.bound,
+			Via:      f.dialer.Remote().String(),
+			Active:   f.conns.active(),
+			Rejected: f.rejected.Load(),
+			Limited:  f.limited.Load(),
+			Status:   f.getStatus().Status,
 		})
 		return true
 	})
@@ -0,0 +1,278 @@
+package phantom
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"kon.nect.sh/specter/tun/client/dialer"
+
+	"go.uber.org/zap"
+)
+
+type gatewayDialFunc func(ctx context.Context) (gatewayConn, error)
+
+func dialGateway(ctx context.Context, logger *zap.Logger, opts gatewayOptions, l Listener) (gatewayConn, error) {
+	parsed, err := dialer.ParseApex(l.Hostname)
+	if err != nil {
+		return gatewayConn{}, fmt.Errorf("error parsing hostname: %w", err)
+	}
+
+	cfg := dialer.DialerConfig{
+		Logger:             logger,
+		Parsed:             parsed,
+		InsecureSkipVerify: l.Insecure,
+		NoReconnection:     false,
+	}
+
+	transport := l.transport()
+	if transport == TransportAuto {
+		return dialAuto(ctx, logger, opts, cfg)
+	}
+	return dialTransport(ctx, transport, cfg, opts.proxy)
+}
+
+const defaultLazyIdleTimeout = time.Minute * 5
+
+// managedDialer holds the gateway dialer of a forwarder. connector.HandleConnections
+// keeps a reference to the dialer for its entire lifetime, so this allows the
+// underlying gateway connection to be replaced without restarting the listener.
+// In lazy mode, the gateway connection is only established on the first Dial and
+// is torn down again once there are no active streams for the idle period.
+type managedDialer struct {
+	parent context.Context
+	dial   gatewayDialFunc
+	lazy   bool
+	idle   time.Duration
+	onReap func() // called after an idle lazy gateway is disconnected
+
+	dialMu sync.Mutex
+	mu     sync.RWMutex
+	cancel context.CancelFunc
+	gw     gatewayConn
+
+	active   atomic.Int64
+	lastUsed atomic.Int64
+}
+
+var _ dialer.TransportDialer = (*managedDialer)(nil)
+
+func newManagedDialer(parent context.Context, dial gatewayDialFunc) *managedDialer {
+	return &managedDialer{
+		parent: parent,
+		dial:   dial,
+	}
+}
+
+func newLazyDialer(parent context.Context, dial gatewayDialFunc, idle time.Duration, onReap func()) *managedDialer {
+	if idle <= 0 {
+		idle = defaultLazyIdleTimeout
+	}
+	m := &managedDialer{
+		parent: parent,
+		dial:   dial,
+		lazy:   true,
+		idle:   idle,
+		onReap: onReap,
+	}
+	go m.reapIdle()
+	return m
+}
+
+// redial establishes a new gateway connection and tears down the previous one
+func (m *managedDialer) redial() error {
+	m.dialMu.Lock()
+	defer m.dialMu.Unlock()
+
+	return m.redialLocked()
+}
+
+// m.dialMu must be held
+func (m *managedDialer) redialLocked() error {
+	ctx, cancel := context.WithCancel(m.parent)
+	gw, err := m.dial(ctx)
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	m.mu.Lock()
+	prev := m.cancel
+	m.cancel = cancel
+	m.gw = gw
+	m.mu.Unlock()
+
+	if prev != nil {
+		prev()
+	}
+	m.touch()
+	return nil
+}
+
+func (m *managedDialer) connect() (dialer.TransportDialer, error) {
+	m.dialMu.Lock()
+	defer m.dialMu.Unlock()
+
+	if td := m.current(); td != nil {
+		return td, nil
+	}
+	if err := m.redialLocked(); err != nil {
+		return nil, err
+	}
+	return m.current(), nil
+}
+
+func (m *managedDialer) current() dialer.TransportDialer {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.gw.dialer
+}
+
+func (m *managedDialer) connected() bool {
+	return m.current() != nil
+}
+
+func (m *managedDialer) touch() {
+	m.lastUsed.Store(time.Now().UnixNano())
+}
+
+func (m *managedDialer) Remote() net.Addr {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.gw.remote == nil {
+		return &net.TCPAddr{}
+	}
+	return m.gw.remote
+}
+
+func (m *managedDialer) transport() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.gw.dialer == nil {
+		return ""
+	}
+	return m.gw.transport
+}
+
+func (m *managedDialer) Dial() (net.Conn, error) {
+	// counted before the gateway is read, so reapIdle leaves it alone from here on
+	m.active.Add(1)
+	conn, err := m.dialStream()
+	if err != nil {
+		m.active.Add(-1)
+		return nil, err
+	}
+
+	m.touch()
+	return &trackedConn{
+		Conn: conn,
+		onClose: func() {
+			m.active.Add(-1)
+			m.touch()
+		},
+	}, nil
+}
+
+func (m *managedDialer) dialStream() (net.Conn, error) {
+	td := m.current()
+	if td == nil {
+		if !m.lazy {
+			return nil, fmt.Errorf("gateway is not connected")
+		}
+		var err error
+		if td, err = m.connect(); err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := td.Dial()
+	if err != nil && m.lazy && m.current() != td {
+		// the gateway was reaped between reading it and dialing through it
+		if td, err = m.connect(); err != nil {
+			return nil, err
+		}
+		return td.Dial()
+	}
+	return conn, err
+}
+
+// probe opens and immediately closes a stream through the gateway without counting
+// it as activity, otherwise health checks would keep lazy connections alive forever.
+// Note that this does reach the published target on the other end of the tunnel.
+func (m *managedDialer) probe() error {
+	td := m.current()
+	if td == nil {
+		return fmt.Errorf("gateway is not connected")
+	}
+	conn, err := td.Dial()
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	return nil
+}
+
+func (m *managedDialer) disconnect() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.cancel = nil
+	m.gw.dialer = nil
+}
+
+func (m *managedDialer) close() {
+	m.disconnect()
+}
+
+func (m *managedDialer) reapIdle() {
+	ticker := time.NewTicker(m.idle / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.parent.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if m.active.Load() > 0 || !m.connected() {
+			continue
+		}
+		if time.Since(time.Unix(0, m.lastUsed.Load())) < m.idle {
+			continue
+		}
+
+		m.dialMu.Lock()
+		reaped := m.active.Load() == 0
+		if reaped {
+			m.disconnect()
+		}
+		m.dialMu.Unlock()
+
+		if reaped && m.onReap != nil {
+			m.onReap()
+		}
+	}
+}
+
+type trackedConn struct {
+	net.Conn
+	once    sync.Once
+	onClose func()
+}
+
+var _ net.Conn = (*trackedConn)(nil)
+
+func (c *trackedConn) Close() error {
+	c.once.Do(c.onClose)
+	return c.Conn.Close()
+}
@@ -0,0 +1,261 @@
+package phantom
+
+import (
+	"fmt"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"go.uber.org/zap"
+)
+
+type GroupConfig struct {
+	Name      string           `json:"name"`
+	Autostart bool             `json:"autostart"`
+	Templates []TemplateConfig `json:"templates,omitempty"`
+}
+
+type GroupState string
+
+const (
+	GroupStopped GroupState = "stopped"
+	GroupPartial GroupState = "partial"
+	GroupRunning GroupState = "running"
+)
+
+type GroupStatus struct {
+	Name      string     `json:"name"`
+	Autostart bool       `json:"autostart"`
+	State     GroupState `json:"state"`
+	Total     int        `json:"total"`
+	Running   int        `json:"running"`
+	Healthy   int        `json:"healthy"`
+	Degraded  int        `json:"degraded"`
+	Failed    int        `json:"failed"`
+}
+
+// app.stateMu must be held
+func (app *Application) groupNames() []string {
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+	for _, g := range app.phantomCfg.Groups {
+		if g.Name == "" || seen[g.Name] {
+			continue
+		}
+		seen[g.Name] = true
+		names = append(names, g.Name)
+	}
+	for _, l := range app.phantomCfg.Listeners {
+		if l.Group == "" || seen[l.Group] {
+			continue
+		}
+		seen[l.Group] = true
+		names = append(names, l.Group)
+	}
+	return names
+}
+
+// app.stateMu must be held
+func (app *Application) groupConfig(name string) (GroupConfig, bool) {
+	for _, g := range app.phantomCfg.Groups {
+		if g.Name == name {
+			return g, true
+		}
+	}
+	return GroupConfig{Name: name}, false
+}
+
+// app.stateMu must be held
+func (app *Application) groupListeners(name string) []Listener {
+	listeners := make([]Listener, 0)
+	for _, l := range app.phantomCfg.Listeners {
+		if l.Group == name {
+			listeners = append(listeners, l)
+		}
+	}
+	return listeners
+}
+
+// app.stateMu must be held
+func (app *Application) getGroupStatus(name string) GroupStatus {
+	g, _ := app.groupConfig(name)
+	status := GroupStatus{
+		Name:      name,
+		Autostart: g.Autostart,
+		State:     GroupStopped,
+	}
+	for _, l := range app.groupListeners(name) {
+		status.Total++
+		f, ok := app.forwarders.Load(l.Listen)
+		if !ok {
+			continue
+		}
+		status.Running++
+		switch f.getStatus().Status {
+		case HealthHealthy, HealthIdle:
+			status.Healthy++
+		case HealthDegraded:
+			status.Degraded++
+		case HealthFailed:
+			status.Failed++
+		}
+	}
+	if status.Running > 0 {
+		if status.Running == status.Total {
+			status.State = GroupRunning
+		} else {
+			status.State = GroupPartial
+		}
+	}
+	return status
+}
+
+func (app *Application) emitGroupStatus(name string) {
+	if name == "" {
+		return
+	}
+
+	app.stateMu.RLock()
+	status := app.getGroupStatus(name)
+	app.stateMu.RUnlock()
+
+	runtime.EventsEmit(app.appCtx, "group:Status", status)
+}
+
+func (app *Application) GetGroups() []GroupStatus {
+	app.stateMu.RLock()
+	defer app.stateMu.RUnlock()
+
+	groups := make([]GroupStatus, 0)
+	for _, name := range app.groupNames() {
+		groups = append(groups, app.getGroupStatus(name))
+	}
+	return groups
+}
+
+func (app *Application) GetGroupStatus(name string) GroupStatus {
+	app.stateMu.RLock()
+	defer app.stateMu.RUnlock()
+
+	return app.getGroupStatus(name)
+}
+
+func (app *Application) StartGroup(name string) ([]ForwarderResult, error) {
+	app.stateMu.Lock()
+	defer app.stateMu.Unlock()
+
+	listeners := app.groupListeners(name)
+	if len(listeners) == 0 {
+		return []ForwarderResult{}, nil
+	}
+
+	app.logger.Info("Starting forwarder group", zap.String("group", name), zap.Int("forwarders", len(listeners)))
+
+	results, err := app.startBatch(listeners, false)
+	app.queueGroupStatus(name)
+	app.emitBatchResult(results)
+
+	if err != nil {
+		return results, fmt.Errorf("failed to start group %s: %w", name, err)
+	}
+	return results, nil
+}
+
+func (app *Application) StopGroup(name string) error {
+	app.stateMu.Lock()
+	defer app.stateMu.Unlock()
+
+	app.logger.Info("Stopping forwarder group", zap.String("group", name))
+
+	// members waiting to be retried would otherwise come back right after being stopped
+	for _, l := range app.groupListeners(name) {
+		app.cancelRetry(l.Listen)
+	}
+	app.forwarders.Range(func(listen string, f *forwarder) bool {
+		if f.cfg.Group != name {
+			return true
+		}
+		app.stopForwarder(f.cfg, f)
+		runtime.EventsEmit(app.appCtx, "forwarder:Stopped", f.cfg.Listen)
+		return true
+	})
+	app.queueGroupStatus(name)
+
+	if app.forwarders.Len() == 0 {
+		runtime.EventsEmit(app.appCtx, "forwarders:Stopped")
+	}
+
+	return nil
+}
+
+func (app *Application) UpdateGroup(g GroupConfig) error {
+	app.stateMu.Lock()
+	defer app.stateMu.Unlock()
+
+	if g.Name == "" {
+		return fmt.Errorf("group name cannot be empty")
+	}
+	for _, t := range g.Templates {
+		if _, err := parseTemplate(t); err != nil {
+			return err
+		}
+	}
+
+	prev, _ := app.groupConfig(g.Name)
+
+	found := false
+	for i := range app.phantomCfg.Groups {
+		if app.phantomCfg.Groups[i].Name == g.Name {
+			app.phantomCfg.Groups[i] = g
+			found = true
+			break
+		}
+	}
+	if !found {
+		app.phantomCfg.Groups = append(app.phantomCfg.Groups, g)
+	}
+
+	if err := app.persistPhantomConfig(app.phantomCfg); err != nil {
+		return fmt.Errorf("failed to persist group config: %w", err)
+	}
+
+	app.effects.push(func() {
+		app.emitGroupStatus(g.Name)
+		// outputs that are no longer configured would never be cleaned up otherwise
+		for _, t := range prev.Templates {
+			if !hasTemplatePath(g.Templates, t.Path) {
+				cleanupTemplate(t)
+			}
+		}
+		app.renderTemplates(g.Name)
+	})
+
+	return nil
+}
+
+func (app *Application) UpdateForwarderGroup(index int, group string) error {
+	app.stateMu.Lock()
+	defer app.stateMu.Unlock()
+
+	l, f, ok, err := app.findForwarder(index)
+	if err != nil {
+		return err
+	}
+
+	app.phantomCfg.Listeners[index].Group = group
+	if ok {
+		f.cfg = app.phantomCfg.Listeners[index]
+		f.setGroup(group)
+	}
+
+	if err := app.persistPhantomConfig(app.phantomCfg); err != nil {
+		return fmt.Errorf("failed to persist forwarder config: %w", err)
+	}
+
+	app.effects.push(func() {
+		app.emitGroupStatus(l.Group)
+		app.emitGroupStatus(group)
+		app.renderTemplates(l.Group)
+		app.renderTemplates(group)
+	})
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package phantom
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"go.uber.org/zap"
+)
+
+type HealthStatus string
+
+const (
+	HealthHealthy  HealthStatus = "healthy"
+	HealthDegraded HealthStatus = "degraded"
+	HealthFailed   HealthStatus = "failed"
+	HealthIdle     HealthStatus = "idle"
+)
+
+const (
+	healthCheckInterval = time.Second * 30
+	healthFailThreshold = 3
+	redialMinBackoff    = time.Second
+	redialMaxBackoff    = time.Minute
+)
+
+type ForwarderStatus struct {
+	Listen    string        `json:"listen"`
+	Address   string        `json:"address"`
+	Status    HealthStatus  `json:"status"`
+	LastError string        `json:"lastError,omitempty"`
+	Transport string        `json:"transport,omitempty"`
+	LastCheck time.Time     `json:"lastCheck"`
+	Routes    []RouteStatus `json:"routes"`
+}
+
+type backoff struct {
+	min, max time.Duration
+	cur      time.Duration
+}
+
+func (b *backoff) next() time.Duration {
+	if b.cur == 0 {
+		b.cur = b.min
+	} else {
+		b.cur *= 2
+		if b.cur > b.max {
+			b.cur = b.max
+		}
+	}
+	return b.cur
+}
+
+func (b *backoff) reset() {
+	b.cur = 0
+}
+
+func (f *forwarder) getStatus() ForwarderStatus {
+	f.statusMu.RLock()
+	defer f.statusMu.RUnlock()
+
+	status := f.status
+	status.Routes = f.dialer.snapshot()
+	return status
+}
+
+func (f *forwarder) setStatus(status HealthStatus, err error) (ForwarderStatus, bool) {
+	f.statusMu.Lock()
+	defer f.statusMu.Unlock()
+
+	prev := f.status
+	f.status.Status = status
+	f.status.LastCheck = time.Now()
+	f.status.Transport = f.dialer.transport()
+	if err != nil {
+		f.status.LastError = err.Error()
+	} else {
+		f.status.LastError = ""
+	}
+
+	return f.status, prev.Status != f.status.Status || prev.LastError != f.status.LastError || prev.Transport != f.status.Transport
+}
+
+func (f *forwarder) getGroup() string {
+	f.statusMu.RLock()
+	defer f.statusMu.RUnlock()
+
+	return f.group
+}
+
+func (f *forwarder) setGroup(group string) {
+	f.statusMu.Lock()
+	defer f.statusMu.Unlock()
+
+	f.group = group
+}
+
+func (app *Application) updateForwarderStatus(f *forwarder, status HealthStatus, err error) {
+	s, changed := f.setStatus(status, err)
+	if changed {
+		s.Routes = f.dialer.snapshot()
+		runtime.EventsEmit(app.appCtx, "forwarder:Status", s)
+		app.queueGroupStatus(f.getGroup())
+	}
+}
+
+func (app *Application) monitorForwarder(logger *zap.Logger, f *forwarder) {
+	timer := time.NewTimer(f.dialer.nextCheck())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-f.monitorCtx.Done():
+			return
+		case <-timer.C:
+		}
+
+		now := time.Now()
+		for _, r := range f.dialer.routes {
+			if f.monitorCtx.Err() != nil {
+				return
+			}
+			if now.Before(r.due) {
+				continue
+			}
+			app.checkRoute(logger.With(zap.String("hostname", r.hostname)), r)
+		}
+
+		status, err := f.dialer.aggregate()
+		app.updateForwarderStatus(f, status, err)
+
+		timer.Reset(f.dialer.nextCheck())
+	}
+}
+
+// checkRoute is only called from the monitor goroutine
+func (app *Application) checkRoute(logger *zap.Logger, r *route) {
+	if r.dialer.lazy && !r.dialer.connected() {
+		// lazy routes connect on demand, nothing to check
+		r.failures = 0
+		r.wait.reset()
+		r.setStatus(HealthIdle, nil)
+		r.due = time.Now().Add(healthCheckInterval)
+		return
+	}
+
+	if r.failures >= healthFailThreshold {
+		app.gateways.invalidate(r.dialer.current())
+		if err := r.dialer.redial(); err != nil {
+			logger.Warn("Failed to re-dial specter gateway", zap.Error(err))
+			r.setStatus(HealthFailed, err)
+			r.due = time.Now().Add(r.wait.next())
+			return
+		}
+		logger.Info("Re-established connection to specter gateway", zap.String("via", r.dialer.Remote().String()))
+	}
+
+	start := time.Now()
+	if err := r.dialer.probe(); err != nil {
+		r.failures++
+		logger.Warn("Forwarder health check failed", zap.Int("failures", r.failures), zap.Error(err))
+		if r.failures >= healthFailThreshold {
+			r.setStatus(HealthFailed, err)
+			r.due = time.Now().Add(r.wait.next())
+			return
+		}
+		r.setStatus(HealthDegraded, err)
+	} else {
+		r.observe(time.Since(start))
+		r.failures = 0
+		r.wait.reset()
+		r.setStatus(HealthHealthy, nil)
+	}
+	r.due = time.Now().Add(healthCheckInterval)
+}
+
+func (app *Application) GetForwarderStatus(listen string) (ForwarderStatus, error) {
+	app.stateMu.RLock()
+	defer app.stateMu.RUnlock()
+
+	f, ok := app.forwarders.Load(listen)
+	if !ok {
+		return ForwarderStatus{}, fmt.Errorf("forwarder %s is not running", listen)
+	}
+
+	return f.getStatus(), nil
+}
@@ -0,0 +1,71 @@
+package phantom
+
+import (
+	"kon.nect.sh/phantom/internal/hostsfile"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"go.uber.org/zap"
+)
+
+const hostsFileTag = "phantom"
+
+type HostsFileConfig struct {
+	Enabled bool   `json:"enabled"`
+	Path    string `json:"path,omitempty"` // defaults to the system hosts file
+}
+
+func (c HostsFileConfig) path() string {
+	if c.Path == "" {
+		return hostsfile.DefaultPath()
+	}
+	return c.Path
+}
+
+// app.stateMu must be held
+func (app *Application) hostsEntries() []hostsfile.Entry {
+	entries := make([]hostsfile.Entry, 0)
+	app.forwarders.Range(func(_ string, f *forwarder) bool {
+		if len(f.cfg.HostAliases) > 0 {
+			entries = append(entries, hostsfile.Entry{
+				IP:    forwarderIP(f.bound).String(),
+				Names: f.cfg.HostAliases,
+			})
+		}
+		return true
+	})
+	return entries
+}
+
+// syncHostsFile rewrites the managed block to match the running forwarders
+func (app *Application) syncHostsFile() {
+	app.hostsMu.Lock()
+	defer app.hostsMu.Unlock()
+
+	app.stateMu.RLock()
+	cfg := app.phantomCfg.HostsFile
+	entries := app.hostsEntries()
+	app.stateMu.RUnlock()
+
+	if !cfg.Enabled {
+		return
+	}
+
+	if err := hostsfile.Update(cfg.path(), hostsFileTag, entries); err != nil {
+		app.logger.Error("Failed to update hosts file", zap.String("path", cfg.path()), zap.Error(err))
+		runtime.EventsEmit(app.appCtx, "hosts:Error", err.Error())
+	}
+}
+
+// cleanupHostsFile removes the managed block, including one left behind by a crash
+func (app *Application) cleanupHostsFile(cfg HostsFileConfig) {
+	app.hostsMu.Lock()
+	defer app.hostsMu.Unlock()
+
+	if !cfg.Enabled {
+		return
+	}
+
+	if err := hostsfile.Remove(cfg.path(), hostsFileTag); err != nil {
+		app.logger.Error("Failed to clean up hosts file", zap.String("path", cfg.path()), zap.Error(err))
+	}
+}
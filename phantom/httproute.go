@@ -0,0 +1,208 @@
+package phantom
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type HTTPRoute struct {
+	Host     string `json:"host"`     // local name in the Host header, without port
+	Hostname string `json:"hostname"` // tunnel hostname to route to
+}
+
+// httpBinding is the local and tunnel side of a proxied request
+type httpBinding struct {
+	scheme string
+	local  string // including port, as sent by the client
+	tunnel string
+	route  *route
+}
+
+type httpBindingKey struct{}
+
+// httpRouter proxies local HTTP requests to the tunnel matching their Host header,
+// and rewrites the headers that refer to either name so that redirects, origin
+// checks and cookies keep working on the local name. Upgraded connections such
+// as WebSocket are passed through by the reverse proxy.
+type httpRouter struct {
+	logger    *zap.Logger
+	routes    map[string]*route // local host to route
+	fallback  *route
+	tracker   *connTracker
+	transport *http.Transport
+	proxy     *httputil.ReverseProxy
+	server    *http.Server
+}
+
+var _ connHandler = (*httpRouter)(nil)
+
+func newHTTPRouter(logger *zap.Logger, l Listener, routes []*route, tracker *connTracker) *httpRouter {
+	byHostname := make(map[string]*route)
+	for _, rt := range routes {
+		byHostname[strings.ToLower(rt.hostname)] = rt
+	}
+
+	tracker.perRequest = true
+	r := &httpRouter{
+		logger:  logger,
+		routes:  make(map[string]*route),
+		tracker: tracker,
+	}
+	for _, hr := range l.HTTPRoutes {
+		r.routes[strings.ToLower(hr.Host)] = byHostname[strings.ToLower(strings.TrimSpace(hr.Hostname))]
+	}
+	if l.Hostname != "" {
+		r.fallback = byHostname[strings.ToLower(strings.TrimSpace(l.Hostname))]
+	}
+
+	r.transport = &http.Transport{
+		DialContext:         r.dial,
+		MaxIdleConnsPerHost: 16,
+		IdleConnTimeout:     time.Second * 90,
+	}
+	r.proxy = &httputil.ReverseProxy{
+		Director:       r.director,
+		ModifyResponse: r.modifyResponse,
+		Transport:      r.transport,
+		ErrorLog:       zap.NewStdLog(logger),
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			logger.Debug("Failed to proxy request", zap.String("host", req.Host), zap.Error(err))
+			http.Error(w, fmt.Sprintf("phantom: %v", err), http.StatusBadGateway)
+		},
+	}
+	r.server = &http.Server{
+		Handler:           r,
+		ReadHeaderTimeout: time.Second * 30,
+		ErrorLog:          zap.NewStdLog(logger),
+	}
+	return r
+}
+
+func (r *httpRouter) match(host string) *route {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if rt, ok := r.routes[strings.ToLower(host)]; ok && rt != nil {
+		return rt
+	}
+	return r.fallback
+}
+
+func (r *httpRouter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.tracker.beginRequest()
+	defer r.tracker.endRequest()
+
+	rt := r.match(req.Host)
+	if rt == nil {
+		http.Error(w, fmt.Sprintf("phantom: no route for host %s", req.Host), http.StatusBadGateway)
+		return
+	}
+
+	b := httpBinding{
+		scheme: "http",
+		local:  req.Host,
+		route:  rt,
+	}
+	if req.TLS != nil {
+		b.scheme = "https"
+	}
+	b.tunnel, _ = splitHostname(rt.hostname)
+
+	r.proxy.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), httpBindingKey{}, b)))
+}
+
+func (r *httpRouter) director(req *http.Request) {
+	b := req.Context().Value(httpBindingKey{}).(httpBinding)
+
+	req.URL.Scheme = "http"
+	req.URL.Host = b.tunnel
+	req.Host = b.tunnel
+	req.Header.Set("X-Forwarded-Host", b.local)
+	req.Header.Set("X-Forwarded-Proto", b.scheme)
+
+	for _, name := range []string{"Origin", "Referer"} {
+		if v := req.Header.Get(name); v != "" {
+			req.Header.Set(name, rewriteURLHost(v, b.local, b.tunnel, ""))
+		}
+	}
+}
+
+func (r *httpRouter) modifyResponse(resp *http.Response) error {
+	b := resp.Request.Context().Value(httpBindingKey{}).(httpBinding)
+
+	if loc := resp.Header.Get("Location"); loc != "" {
+		resp.Header.Set("Location", rewriteURLHost(loc, b.tunnel, b.local, b.scheme))
+	}
+
+	if cookies := resp.Header.Values("Set-Cookie"); len(cookies) > 0 {
+		resp.Header.Del("Set-Cookie")
+		for _, c := range cookies {
+			resp.Header.Add("Set-Cookie", stripCookieDomain(c, b.tunnel))
+		}
+	}
+
+	return nil
+}
+
+// dial is keyed by the tunnel host set by the director
+func (r *httpRouter) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	b, ok := ctx.Value(httpBindingKey{}).(httpBinding)
+	if !ok {
+		return nil, fmt.Errorf("no route for %s", addr)
+	}
+	return b.route.dial(r.logger)
+}
+
+func (r *httpRouter) serve(listener net.Listener) {
+	if err := r.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		r.logger.Debug("Stopped serving HTTP", zap.Error(err))
+	}
+}
+
+func (r *httpRouter) close() {
+	r.server.Close()
+	r.transport.CloseIdleConnections()
+}
+
+// rewriteURLHost replaces the host of an absolute URL if it matches from. The
+// scheme is replaced as well if one is given.
+func rewriteURLHost(raw, from, to, scheme string) string {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() {
+		return raw
+	}
+	if !strings.EqualFold(u.Host, from) && !strings.EqualFold(u.Hostname(), from) {
+		return raw
+	}
+	u.Host = to
+	if scheme != "" {
+		u.Scheme = scheme
+	}
+	return u.String()
+}
+
+// stripCookieDomain drops a Domain attribute covering the tunnel host, so the
+// browser scopes the cookie to the local name instead of rejecting it
+func stripCookieDomain(cookie, tunnel string) string {
+	parts := strings.Split(cookie, ";")
+	kept := parts[:1]
+	for _, attr := range parts[1:] {
+		name, value, _ := strings.Cut(strings.TrimSpace(attr), "=")
+		if strings.EqualFold(name, "domain") {
+			domain := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(value), "."))
+			if domain == tunnel || strings.HasSuffix(tunnel, "."+domain) {
+				continue
+			}
+		}
+		kept = append(kept, attr)
+	}
+	return strings.Join(kept, ";")
+}
@@ -0,0 +1,138 @@
+package phantom
+
+import (
+	"testing"
+)
+
+func TestRewriteURLHost(t *testing.T) {
+	cases := []struct {
+		name     string
+		raw      string
+		from     string
+		to       string
+		scheme   string
+		expected string
+	}{
+		{
+			name:     "host",
+			raw:      "https://app.example.com/login?next=%2F",
+			from:     "app.example.com",
+			to:       "app.localhost:8080",
+			scheme:   "http",
+			expected: "http://app.localhost:8080/login?next=%2F",
+		},
+		{
+			name:     "host with port",
+			raw:      "http://app.example.com:443/",
+			from:     "app.example.com",
+			to:       "app.localhost:8080",
+			expected: "http://app.localhost:8080/",
+		},
+		{
+			name:     "local with port",
+			raw:      "http://app.localhost:8080/path",
+			from:     "app.localhost:8080",
+			to:       "app.example.com",
+			expected: "http://app.example.com/path",
+		},
+		{
+			name:     "case insensitive",
+			raw:      "https://App.Example.com/",
+			from:     "app.example.com",
+			to:       "localhost:3000",
+			expected: "https://localhost:3000/",
+		},
+		{
+			name:     "ipv6 local",
+			raw:      "http://[::1]:8080/callback",
+			from:     "[::1]:8080",
+			to:       "app.example.com",
+			expected: "http://app.example.com/callback",
+		},
+		{
+			name:     "ipv6 without port",
+			raw:      "http://[::1]/callback",
+			from:     "::1",
+			to:       "app.example.com",
+			expected: "http://app.example.com/callback",
+		},
+		{
+			name:     "other host",
+			raw:      "https://auth.example.com/",
+			from:     "app.example.com",
+			to:       "app.localhost:8080",
+			scheme:   "http",
+			expected: "https://auth.example.com/",
+		},
+		{
+			name:     "relative",
+			raw:      "/login",
+			from:     "app.example.com",
+			to:       "app.localhost:8080",
+			scheme:   "http",
+			expected: "/login",
+		},
+		{
+			name:     "invalid",
+			raw:      "http://[::1/",
+			from:     "::1",
+			to:       "app.example.com",
+			expected: "http://[::1/",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := rewriteURLHost(tc.raw, tc.from, tc.to, tc.scheme); got != tc.expected {
+				t.Fatalf("expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestStripCookieDomain(t *testing.T) {
+	cases := []struct {
+		name     string
+		cookie   string
+		expected string
+	}{
+		{
+			name:     "without domain",
+			cookie:   "session=abc; Path=/; HttpOnly",
+			expected: "session=abc; Path=/; HttpOnly",
+		},
+		{
+			name:     "tunnel domain",
+			cookie:   "session=abc; Domain=app.example.com; Path=/",
+			expected: "session=abc; Path=/",
+		},
+		{
+			name:     "parent domain",
+			cookie:   "session=abc; Path=/; domain=.Example.com; Secure",
+			expected: "session=abc; Path=/; Secure",
+		},
+		{
+			name:     "unrelated domain",
+			cookie:   "session=abc; Domain=other.com",
+			expected: "session=abc; Domain=other.com",
+		},
+		{
+			name:     "suffix without dot",
+			cookie:   "session=abc; Domain=ple.com",
+			expected: "session=abc; Domain=ple.com",
+		},
+		{
+			name:     "domain in value",
+			cookie:   "domain=app.example.com; Path=/",
+			expected: "domain=app.example.com; Path=/",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := stripCookieDomain(tc.cookie, "app.example.com"); got != tc.expected {
+				t.Fatalf("expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
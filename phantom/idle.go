@@ -0,0 +1,121 @@
+package phantom
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"kon.nect.sh/specter/tun/client"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"go.uber.org/zap"
+)
+
+const idleCheckInterval = time.Second * 30
+
+// watchIdle stops the forwarder once it has gone without connections for its idle
+// timeout, which can be changed while it runs. The config entry is kept so it can
+// be started again.
+func (app *Application) watchIdle(logger *zap.Logger, f *forwarder, listen string) {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.monitorCtx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		timeout := time.Duration(f.idleTimeout.Load())
+		if timeout <= 0 || f.conns.idle() < timeout {
+			continue
+		}
+
+		app.stateMu.Lock()
+		// the forwarder may have been stopped or replaced in the meantime
+		if cur, ok := app.forwarders.Load(listen); !ok || cur != f || f.conns.idle() < timeout {
+			app.stateMu.Unlock()
+			continue
+		}
+		logger.Info("Stopping idle forwarder", zap.Duration("timeout", timeout))
+		app.stopForwarder(f.cfg, f)
+		runtime.EventsEmit(app.appCtx, "forwarder:Stopped", listen)
+		runtime.EventsEmit(app.appCtx, "forwarder:IdleStopped", listen, int(timeout/time.Minute))
+		if app.forwarders.Len() == 0 {
+			runtime.EventsEmit(app.appCtx, "forwarders:Stopped")
+		}
+		app.stateMu.Unlock()
+		return
+	}
+}
+
+// markPublished starts the TTL of the given tunnels that were not published yet.
+// Publishing them again, as every sync does, keeps their TTL running.
+// app.stateMu must be held
+func (app *Application) markPublished(now time.Time, tunnels []client.Tunnel) {
+	for _, t := range tunnels {
+		key := strings.ToLower(t.Hostname)
+		if _, ok := app.published[key]; !ok {
+			app.published[key] = now
+		}
+	}
+}
+
+// ttlExceeded reports whether the tunnel has been published for longer than its TTL
+// app.stateMu must be held
+func (app *Application) ttlExceeded(hostname string, now time.Time) (time.Duration, bool) {
+	since, ok := app.published[strings.ToLower(hostname)]
+	if !ok {
+		return 0, false
+	}
+	i, ok := app.tunnelMeta(hostname)
+	if !ok || app.phantomCfg.TunnelMeta[i].TTL <= 0 {
+		return 0, false
+	}
+	ttl := time.Duration(app.phantomCfg.TunnelMeta[i].TTL) * time.Minute
+	return ttl, now.Sub(since) >= ttl
+}
+
+// runTunnelExpiry periodically unpublishes the tunnels that have outlived their TTL
+func (app *Application) runTunnelExpiry(ctx context.Context) {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			app.stateMu.Lock()
+			app.expireTunnels(now)
+			app.stateMu.Unlock()
+		}
+	}
+}
+
+// expireTunnels unpublishes the tunnels that have outlived their TTL. They stay
+// in the client config, but are withheld from syncs until published by hand.
+// app.stateMu must be held
+func (app *Application) expireTunnels(now time.Time) {
+	if app.cli == nil {
+		return
+	}
+
+	for _, t := range app.cli.GetCurrentConfig().Tunnels {
+		ttl, exceeded := app.ttlExceeded(t.Hostname, now)
+		if !exceeded {
+			continue
+		}
+
+		app.logger.Info("Unpublishing tunnel after its TTL", zap.String("hostname", t.Hostname), zap.Duration("ttl", ttl))
+		if err := app.cli.UnpublishTunnel(app.appCtx, t); err != nil {
+			app.logger.Warn("Failed to unpublish expired tunnel", zap.String("hostname", t.Hostname), zap.Error(err))
+			continue
+		}
+		key := strings.ToLower(t.Hostname)
+		delete(app.published, key)
+		app.expired[key] = true
+		runtime.EventsEmit(app.appCtx, "tunnel:Expired", t.Hostname)
+	}
+}
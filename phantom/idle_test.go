@@ -0,0 +1,129 @@
+package phantom
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"kon.nect.sh/specter/tun/client"
+)
+
+func TestConnTrackerIdle(t *testing.T) {
+	clock := newTestClock(time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC))
+	tracker := newConnTracker()
+	tracker.clock = clock
+	tracker.lastActive = clock.Now()
+
+	clock.advance(time.Minute * 5)
+	if idle := tracker.idle(); idle != time.Minute*5 {
+		t.Fatalf("expected to be idle for 5m since creation, got %s", idle)
+	}
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	conn := tracker.track(local)
+
+	clock.advance(time.Hour)
+	if idle := tracker.idle(); idle != 0 {
+		t.Fatalf("expected no idle time with an active connection, got %s", idle)
+	}
+
+	conn.Close()
+	if idle := tracker.idle(); idle != 0 {
+		t.Fatalf("expected the idle time to restart when the connection closed, got %s", idle)
+	}
+
+	clock.advance(time.Minute * 10)
+	if idle := tracker.idle(); idle != time.Minute*10 {
+		t.Fatalf("expected to be idle for 10m, got %s", idle)
+	}
+}
+
+func TestConnTrackerIdleRequests(t *testing.T) {
+	clock := newTestClock(time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC))
+	tracker := newConnTracker()
+	tracker.clock = clock
+	tracker.lastActive = clock.Now()
+	tracker.perRequest = true
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	conn := tracker.track(local)
+	defer conn.Close()
+
+	// an idle keep-alive connection does not count as activity
+	clock.advance(time.Minute * 5)
+	if active := tracker.active(); active != 0 {
+		t.Fatalf("expected no active requests, got %d", active)
+	}
+	if idle := tracker.idle(); idle != time.Minute*5 {
+		t.Fatalf("expected to be idle for 5m with a keep-alive connection, got %s", idle)
+	}
+
+	tracker.beginRequest()
+	clock.advance(time.Hour)
+	if idle := tracker.idle(); idle != 0 {
+		t.Fatalf("expected no idle time with a request in flight, got %s", idle)
+	}
+
+	tracker.endRequest()
+	clock.advance(time.Minute)
+	if idle := tracker.idle(); idle != time.Minute {
+		t.Fatalf("expected the idle time to restart when the request finished, got %s", idle)
+	}
+}
+
+func TestTunnelTTL(t *testing.T) {
+	start := time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)
+	app := &Application{
+		phantomCfg: &PhantomConfig{
+			TunnelMeta: []TunnelMetadata{
+				{Hostname: "ttl.example.com", TTL: 30},
+				{Hostname: "closed.example.com", Schedule: "* 0-8 * * *"},
+			},
+		},
+		published: make(map[string]time.Time),
+		expired:   make(map[string]bool),
+	}
+	tunnels := []client.Tunnel{
+		{Hostname: "TTL.example.com"},
+		{Hostname: "other.example.com"},
+	}
+
+	app.markPublished(start, tunnels)
+
+	// syncing publishes every tunnel again, which must not restart their TTL
+	app.markPublished(start.Add(time.Minute*20), tunnels)
+	if since := app.published["ttl.example.com"]; !since.Equal(start) {
+		t.Fatalf("expected the TTL to start at the first publish, got %s", since)
+	}
+
+	withheld := app.withheldTunnels(start.Add(time.Minute * 29))
+	if withheld["ttl.example.com"] {
+		t.Fatal("expected the tunnel to be published within its TTL")
+	}
+	if !withheld["closed.example.com"] {
+		t.Fatal("expected the tunnel outside its schedule to be withheld")
+	}
+	if withheld["other.example.com"] {
+		t.Fatal("expected the tunnel without TTL or schedule to be published")
+	}
+
+	if !app.withheldTunnels(start.Add(time.Minute * 30))["ttl.example.com"] {
+		t.Fatal("expected the tunnel to be withheld once its TTL ran out")
+	}
+
+	// once unpublished, the tunnel stays withheld until published by hand
+	delete(app.published, "ttl.example.com")
+	app.expired["ttl.example.com"] = true
+	if !app.withheldTunnels(start.Add(time.Hour))["ttl.example.com"] {
+		t.Fatal("expected the expired tunnel to be withheld")
+	}
+
+	delete(app.expired, "ttl.example.com")
+	republished := start.Add(time.Hour)
+	app.markPublished(republished, tunnels)
+	if since := app.published["ttl.example.com"]; !since.Equal(republished) {
+		t.Fatalf("expected the TTL to start again when published again, got %s", since)
+	}
+}
@@ -0,0 +1,267 @@
+package phantom
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"kon.nect.sh/phantom/internal/schedule"
+
+	"go.uber.org/zap"
+)
+
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	clock  schedule.Clock
+}
+
+func newTokenBucket(rate float64, burst float64) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   schedule.System.Now(),
+		clock:  schedule.System,
+	}
+}
+
+// tb.mu must be held
+func (tb *tokenBucket) refill() {
+	now := tb.clock.Now()
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+}
+
+func (tb *tokenBucket) allow() bool {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.refill()
+	if tb.tokens < 1 {
+		return false
+	}
+	tb.tokens--
+	return true
+}
+
+// reserve takes n tokens unconditionally and returns how long the caller
+// has to wait before the tokens are actually available
+func (tb *tokenBucket) reserve(n float64) time.Duration {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.refill()
+	tb.tokens -= n
+	if tb.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
+
+func (tb *tokenBucket) wait(ctx context.Context, n int) error {
+	d := tb.reserve(float64(n))
+	if d == 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-tb.clock.After(d):
+		return nil
+	}
+}
+
+type connectionLimits struct {
+	slots    chan struct{}
+	rate     *tokenBucket
+	queue    bool
+	upload   *tokenBucket
+	download *tokenBucket
+}
+
+func newConnectionLimits(l Listener) *connectionLimits {
+	c := &connectionLimits{
+		queue: l.QueueExcess,
+	}
+	if l.MaxConnections > 0 {
+		c.slots = make(chan struct{}, l.MaxConnections)
+	}
+	if l.ConnectionsPerSecond > 0 {
+		c.rate = newTokenBucket(float64(l.ConnectionsPerSecond), float64(l.ConnectionsPerSecond))
+	}
+	if l.UploadLimit > 0 {
+		c.upload = newTokenBucket(float64(l.UploadLimit), float64(l.UploadLimit))
+	}
+	if l.DownloadLimit > 0 {
+		c.download = newTokenBucket(float64(l.DownloadLimit), float64(l.DownloadLimit))
+	}
+	return c
+}
+
+func (c *connectionLimits) configured() bool {
+	return c.slots != nil || c.rate != nil || c.upload != nil || c.download != nil
+}
+
+// limitedListener enforces the concurrency and connection rate limits of a forwarder
+// at accept time. In queue mode, excess connections are held in the kernel backlog
+// until a slot frees up; otherwise they are closed immediately.
+type limitedListener struct {
+	net.Listener
+	ctx     context.Context
+	logger  *zap.Logger
+	limits  *connectionLimits
+	limited *atomic.Uint64
+}
+
+var _ net.Listener = (*limitedListener)(nil)
+
+func (l *limitedListener) acquire() (acquired bool, err error) {
+	if l.limits.slots == nil {
+		return true, nil
+	}
+	if l.limits.queue {
+		select {
+		case l.limits.slots <- struct{}{}:
+			return true, nil
+		case <-l.ctx.Done():
+			return false, net.ErrClosed
+		}
+	}
+	select {
+	case l.limits.slots <- struct{}{}:
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+func (l *limitedListener) release() {
+	if l.limits.slots != nil {
+		<-l.limits.slots
+	}
+}
+
+func (l *limitedListener) throttle() (allowed bool, err error) {
+	if l.limits.rate == nil {
+		return true, nil
+	}
+	if l.limits.queue {
+		if err := l.limits.rate.wait(l.ctx, 1); err != nil {
+			return false, net.ErrClosed
+		}
+		return true, nil
+	}
+	return l.limits.rate.allow(), nil
+}
+
+func (l *limitedListener) Accept() (net.Conn, error) {
+	for {
+		// in queue mode the slot is taken before accepting, so excess connections wait in the backlog
+		if l.limits.queue {
+			if _, err := l.acquire(); err != nil {
+				return nil, err
+			}
+		}
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			if l.limits.queue {
+				l.release()
+			}
+			return nil, err
+		}
+		if !l.limits.queue {
+			if ok, _ := l.acquire(); !ok {
+				l.reject(conn, "maximum concurrent connections reached")
+				continue
+			}
+		}
+		allowed, err := l.throttle()
+		if err != nil {
+			l.release()
+			conn.Close()
+			return nil, err
+		}
+		if !allowed {
+			l.release()
+			l.reject(conn, "connection rate exceeded")
+			continue
+		}
+		return &limitedConn{
+			Conn:     conn,
+			ctx:      l.ctx,
+			upload:   l.limits.upload,
+			download: l.limits.download,
+			release:  l.release,
+		}, nil
+	}
+}
+
+func (l *limitedListener) reject(conn net.Conn, reason string) {
+	l.limited.Add(1)
+	l.logger.Warn("Rejected connection by limits", zap.String("remote", conn.RemoteAddr().String()), zap.String("reason", reason))
+	conn.Close()
+}
+
+// limitedConn is the local side of a proxied connection: Read carries data
+// toward the tunnel (upload) and Write carries data from the tunnel (download).
+type limitedConn struct {
+	net.Conn
+	ctx      context.Context
+	upload   *tokenBucket
+	download *tokenBucket
+	release  func()
+	once     sync.Once
+}
+
+var _ net.Conn = (*limitedConn)(nil)
+
+func (c *limitedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 && c.upload != nil {
+		if werr := c.upload.wait(c.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+func (c *limitedConn) Write(b []byte) (int, error) {
+	if c.download == nil {
+		return c.Conn.Write(b)
+	}
+	var written int
+	chunk := int(c.download.burst)
+	for len(b) > 0 {
+		size := len(b)
+		if size > chunk {
+			size = chunk
+		}
+		if err := c.download.wait(c.ctx, size); err != nil {
+			return written, err
+		}
+		n, err := c.Conn.Write(b[:size])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[size:]
+	}
+	return written, nil
+}
+
+func (c *limitedConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
@@ -0,0 +1,480 @@
+package phantom
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestTokenBucket(t *testing.T) {
+	type step struct {
+		advance time.Duration
+		allowed bool
+	}
+	cases := []struct {
+		name  string
+		rate  float64
+		burst float64
+		steps []step
+	}{
+		{
+			name:  "burst then empty",
+			rate:  1,
+			burst: 3,
+			steps: []step{{0, true}, {0, true}, {0, true}, {0, false}},
+		},
+		{
+			name:  "refills at rate",
+			rate:  2,
+			burst: 1,
+			steps: []step{{0, true}, {0, false}, {time.Millisecond * 250, false}, {time.Millisecond * 250, true}, {0, false}},
+		},
+		{
+			name:  "refill is capped at burst",
+			rate:  10,
+			burst: 2,
+			steps: []step{{0, true}, {0, true}, {time.Hour, true}, {0, true}, {0, false}},
+		},
+		{
+			name:  "burst below one is raised to one",
+			rate:  1,
+			burst: 0,
+			steps: []step{{0, true}, {0, false}, {time.Second, true}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := newTestClock(time.Unix(0, 0))
+			tb := newTokenBucket(tc.rate, tc.burst)
+			tb.clock = clock
+			tb.last = clock.Now()
+
+			for i, s := range tc.steps {
+				clock.advance(s.advance)
+				if got := tb.allow(); got != s.allowed {
+					t.Fatalf("step %d: expected allowed %t, got %t", i, s.allowed, got)
+				}
+			}
+		})
+	}
+}
+
+func TestTokenBucketReserve(t *testing.T) {
+	cases := []struct {
+		name    string
+		rate    float64
+		burst   float64
+		reserve []float64
+		waits   []time.Duration
+	}{
+		{
+			name:    "within burst",
+			rate:    100,
+			burst:   100,
+			reserve: []float64{40, 60},
+			waits:   []time.Duration{0, 0},
+		},
+		{
+			name:    "debt accumulates",
+			rate:    100,
+			burst:   100,
+			reserve: []float64{100, 50, 50},
+			waits:   []time.Duration{0, time.Millisecond * 500, time.Second},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := newTestClock(time.Unix(0, 0))
+			tb := newTokenBucket(tc.rate, tc.burst)
+			tb.clock = clock
+			tb.last = clock.Now()
+
+			for i, n := range tc.reserve {
+				if got := tb.reserve(n); got != tc.waits[i] {
+					t.Fatalf("reservation %d: expected wait %s, got %s", i, tc.waits[i], got)
+				}
+			}
+		})
+	}
+}
+
+// pipeListener hands out the server ends of net.Pipe connections pushed by the test
+type pipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+// dial pushes a new connection to the listener and returns the client end
+func (l *pipeListener) dial(t *testing.T) net.Conn {
+	t.Helper()
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+	case <-time.After(time.Second):
+		t.Fatal("listener did not accept the connection")
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func newTestLimitedListener(t *testing.T, l Listener) (*limitedListener, *pipeListener) {
+	ctx, cancel := context.WithCancel(context.Background())
+	pl := newPipeListener()
+	t.Cleanup(func() {
+		cancel()
+		pl.Close()
+	})
+	return &limitedListener{
+		Listener: pl,
+		ctx:      ctx,
+		logger:   zap.NewNop(),
+		limits:   newConnectionLimits(l),
+		limited:  &atomic.Uint64{},
+	}, pl
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+func acceptAsync(l net.Listener) <-chan acceptResult {
+	ch := make(chan acceptResult, 1)
+	go func() {
+		conn, err := l.Accept()
+		ch <- acceptResult{conn, err}
+	}()
+	return ch
+}
+
+func expectClosed(t *testing.T, conn net.Conn) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF && err != io.ErrClosedPipe {
+		t.Fatalf("expected the connection to be closed, got %v", err)
+	}
+}
+
+func TestLimitedListener(t *testing.T) {
+	cases := []struct {
+		name    string
+		limits  Listener
+		queue   bool // whether the excess connection is expected to be held rather than rejected
+		limited uint64
+	}{
+		{
+			name:    "reject over max connections",
+			limits:  Listener{MaxConnections: 1},
+			limited: 1,
+		},
+		{
+			name:   "queue over max connections",
+			limits: Listener{MaxConnections: 1, QueueExcess: true},
+			queue:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ll, pl := newTestLimitedListener(t, tc.limits)
+
+			accepted := acceptAsync(ll)
+			pl.dial(t)
+			first := <-accepted
+			if first.err != nil {
+				t.Fatalf("unexpected error accepting first connection: %v", first.err)
+			}
+
+			accepted = acceptAsync(ll)
+			if tc.queue {
+				// the slot is taken, so the listener must not even accept
+				select {
+				case l := <-pl.conns:
+					t.Fatalf("unexpected accept of %v while at the limit", l)
+				case r := <-accepted:
+					t.Fatalf("unexpected accept while at the limit: %v", r.err)
+				case <-time.After(time.Millisecond * 100):
+				}
+			} else {
+				expectClosed(t, pl.dial(t))
+			}
+
+			first.conn.Close()
+			pl.dial(t)
+
+			select {
+			case r := <-accepted:
+				if r.err != nil {
+					t.Fatalf("unexpected error accepting after release: %v", r.err)
+				}
+				r.conn.Close()
+			case <-time.After(time.Second):
+				t.Fatal("connection was not accepted after the slot was released")
+			}
+
+			if got := ll.limited.Load(); got != tc.limited {
+				t.Fatalf("expected %d limited connections, got %d", tc.limited, got)
+			}
+		})
+	}
+}
+
+func TestLimitedListenerRate(t *testing.T) {
+	ll, pl := newTestLimitedListener(t, Listener{ConnectionsPerSecond: 1})
+
+	accepted := acceptAsync(ll)
+	pl.dial(t)
+	if r := <-accepted; r.err != nil {
+		t.Fatalf("unexpected error accepting first connection: %v", r.err)
+	}
+
+	accepted = acceptAsync(ll)
+	expectClosed(t, pl.dial(t))
+	if got := ll.limited.Load(); got != 1 {
+		t.Fatalf("expected 1 limited connection, got %d", got)
+	}
+
+	ll.Close()
+	if r := <-accepted; r.err == nil {
+		t.Fatal("expected accept to fail once the listener is closed")
+	}
+}
+
+func TestLimitedConnBandwidth(t *testing.T) {
+	const (
+		limit   = 10_000
+		payload = 15_000
+		// the first limit bytes go through as burst, the rest at limit per second
+		expected = time.Millisecond * 500
+	)
+
+	cases := []struct {
+		name   string
+		limits Listener
+		// transfer moves payload bytes through the limited side of the pipe
+		transfer func(limited net.Conn, peer net.Conn) error
+	}{
+		{
+			name:   "download",
+			limits: Listener{DownloadLimit: limit},
+			transfer: func(limited net.Conn, peer net.Conn) error {
+				go io.Copy(io.Discard, peer)
+				_, err := limited.Write(make([]byte, payload))
+				return err
+			},
+		},
+		{
+			name:   "upload",
+			limits: Listener{UploadLimit: limit},
+			transfer: func(limited net.Conn, peer net.Conn) error {
+				go func() {
+					peer.Write(make([]byte, payload))
+					peer.Close()
+				}()
+				_, err := io.Copy(io.Discard, limited)
+				return err
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			limits := newConnectionLimits(tc.limits)
+			local, peer := net.Pipe()
+			defer peer.Close()
+
+			released := false
+			conn := &limitedConn{
+				Conn:     local,
+				ctx:      context.Background(),
+				upload:   limits.upload,
+				download: limits.download,
+				release:  func() { released = true },
+			}
+
+			start := time.Now()
+			if err := tc.transfer(conn, peer); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			elapsed := time.Since(start)
+
+			if elapsed < expected-time.Millisecond*50 {
+				t.Fatalf("expected transfer to be capped to about %s, took %s", expected, elapsed)
+			}
+			if elapsed > expected*4 {
+				t.Fatalf("transfer took far longer than the cap allows: %s", elapsed)
+			}
+
+			conn.Close()
+			conn.Close()
+			if !released {
+				t.Fatal("expected the connection slot to be released on close")
+			}
+		})
+	}
+}
+
+// fakeGateway is a gateway connection that counts the streams dialed through it
+type fakeGateway struct {
+	dials atomic.Int64
+}
+
+func (g *fakeGateway) Remote() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 443}
+}
+
+func (g *fakeGateway) Dial() (net.Conn, error) {
+	g.dials.Add(1)
+	local, remote := net.Pipe()
+	go func() {
+		io.Copy(io.Discard, remote)
+		remote.Close()
+	}()
+	return local, nil
+}
+
+// forward stands in for connector.HandleConnections, dialing a gateway stream
+// for every connection the listener hands out
+func forward(l net.Listener, d *managedDialer) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			stream, err := d.Dial()
+			if err != nil {
+				return
+			}
+			defer stream.Close()
+			io.Copy(stream, conn)
+		}()
+	}
+}
+
+func expectDials(t *testing.T, gw *fakeGateway, expected int64) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for gw.dials.Load() != expected && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if got := gw.dials.Load(); got != expected {
+		t.Fatalf("expected %d gateway dials, got %d", expected, got)
+	}
+}
+
+func newTestForwarding(t *testing.T, l Listener) (*limitedListener, *pipeListener, *fakeGateway, *testClock) {
+	ll, pl := newTestLimitedListener(t, l)
+	clock := newTestClock(time.Unix(0, 0))
+	if ll.limits.rate != nil {
+		ll.limits.rate.clock = clock
+		ll.limits.rate.last = clock.Now()
+	}
+
+	gw := &fakeGateway{}
+	d := newManagedDialer(ll.ctx, func(ctx context.Context) (gatewayConn, error) {
+		return gatewayConn{remote: gw.Remote(), dialer: gw}, nil
+	})
+	if _, err := d.connect(); err != nil {
+		t.Fatal(err)
+	}
+	go forward(ll, d)
+
+	return ll, pl, gw, clock
+}
+
+func TestLimitedForwardingRate(t *testing.T) {
+	ll, pl, gw, clock := newTestForwarding(t, Listener{ConnectionsPerSecond: 1})
+
+	pl.dial(t)
+	expectDials(t, gw, 1)
+
+	// rejected connections never reach the gateway
+	expectClosed(t, pl.dial(t))
+	expectClosed(t, pl.dial(t))
+	expectDials(t, gw, 1)
+	if got := ll.limited.Load(); got != 2 {
+		t.Fatalf("expected 2 limited connections, got %d", got)
+	}
+
+	clock.advance(time.Second)
+	pl.dial(t)
+	expectDials(t, gw, 2)
+}
+
+func TestLimitedForwardingQueuedRate(t *testing.T) {
+	ll, pl, gw, clock := newTestForwarding(t, Listener{ConnectionsPerSecond: 1, QueueExcess: true})
+
+	pl.dial(t)
+	expectDials(t, gw, 1)
+
+	// the excess connection waits for the next token instead of being rejected
+	pl.dial(t)
+	if wait := clock.waitTimer(t); wait != time.Second {
+		t.Fatalf("expected to wait a second for the next token, waited %s", wait)
+	}
+	expectDials(t, gw, 1)
+
+	clock.advance(time.Second)
+	expectDials(t, gw, 2)
+	if got := ll.limited.Load(); got != 0 {
+		t.Fatalf("expected no limited connections, got %d", got)
+	}
+}
+
+func TestLimitedForwardingMaxConnections(t *testing.T) {
+	ll, pl, gw, _ := newTestForwarding(t, Listener{MaxConnections: 1})
+
+	first := pl.dial(t)
+	expectDials(t, gw, 1)
+
+	expectClosed(t, pl.dial(t))
+	expectDials(t, gw, 1)
+	if got := ll.limited.Load(); got != 1 {
+		t.Fatalf("expected 1 limited connection, got %d", got)
+	}
+
+	// closing the first connection frees its slot for the next one
+	first.Close()
+	deadline := time.Now().Add(time.Second)
+	for len(ll.limits.slots) > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	pl.dial(t)
+	expectDials(t, gw, 2)
+}
@@ -0,0 +1,178 @@
+package phantom
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"go.uber.org/zap"
+)
+
+// MirrorConfig keeps a forwarder for every registered hostname of the client
+type MirrorConfig struct {
+	Enabled bool   `json:"enabled"`
+	Pattern string `json:"pattern,omitempty"` // glob matched against hostnames, empty for all
+	Host    string `json:"host,omitempty"`    // local address to listen on, defaults to 127.0.0.1
+	Group   string `json:"group,omitempty"`
+}
+
+func (c MirrorConfig) match(hostname string) bool {
+	if c.Pattern == "" {
+		return true
+	}
+	ok, err := path.Match(strings.ToLower(c.Pattern), strings.ToLower(hostname))
+	return err == nil && ok
+}
+
+type MirrorEntry struct {
+	Hostname string `json:"hostname"`
+	Listen   string `json:"listen"`
+	Address  string `json:"address,omitempty"`
+	Running  bool   `json:"running"`
+}
+
+// reconcileMirror adds a forwarder for every matching hostname that does not have
+// one yet, and removes mirrored forwarders whose hostname went away. Forwarders
+// configured by hand are never touched.
+// app.stateMu must be held
+func (app *Application) reconcileMirror(hostnames []string) error {
+	cfg := app.phantomCfg.Mirror
+
+	want := make(map[string]bool)
+	if cfg.Enabled {
+		for _, h := range hostnames {
+			if cfg.match(h) {
+				want[strings.ToLower(h)] = true
+			}
+		}
+	}
+
+	var (
+		kept    = make([]Listener, 0, len(app.phantomCfg.Listeners))
+		covered = make(map[string]bool)
+		changed = false
+	)
+	for _, l := range app.phantomCfg.Listeners {
+		key := strings.ToLower(l.Hostname)
+		if l.Mirrored && !want[key] {
+			app.logger.Info("Removing mirrored forwarder", zap.Object("listener", &l))
+			app.cancelRetry(l.Listen)
+			if f, ok := app.forwarders.Load(l.Listen); ok {
+				app.stopForwarder(l, f)
+				runtime.EventsEmit(app.appCtx, "forwarder:Stopped", l.Listen)
+			}
+			changed = true
+			continue
+		}
+		covered[key] = true
+		kept = append(kept, l)
+	}
+	app.phantomCfg.Listeners = kept
+
+	toStart := make([]Listener, 0)
+	for _, h := range hostnames {
+		key := strings.ToLower(h)
+		if !want[key] || covered[key] {
+			continue
+		}
+		covered[key] = true
+
+		listen, err := app.suggestListenAddress(cfg.Host)
+		if err != nil {
+			return fmt.Errorf("failed to assign port for %s: %w", h, err)
+		}
+		l := Listener{
+			Label:    h,
+			Listen:   listen,
+			Hostname: h,
+			Group:    cfg.Group,
+			Mirrored: true,
+		}
+		app.logger.Info("Adding mirrored forwarder", zap.Object("listener", &l))
+		app.phantomCfg.Listeners = append(app.phantomCfg.Listeners, l)
+		toStart = append(toStart, l)
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+
+	if err := app.persistPhantomConfig(app.phantomCfg); err != nil {
+		return fmt.Errorf("failed to persist mirrored forwarders: %w", err)
+	}
+
+	if len(toStart) > 0 {
+		results, _ := app.startBatch(toStart, false)
+		app.emitBatchResult(results)
+	}
+
+	runtime.EventsEmit(app.appCtx, "mirror:Updated", app.getMirrorMapping())
+	return nil
+}
+
+// queueMirrorSync syncs the mirrored forwarders on the effect queue, as listing the
+// registered hostnames is a round trip to the gateway
+func (app *Application) queueMirrorSync() {
+	app.effects.push(func() {
+		if err := app.SyncMirror(); err != nil {
+			app.logger.Error("Failed to sync mirrored forwarders", zap.Error(err))
+		}
+	})
+}
+
+// SyncMirror brings the mirrored forwarders in line with the registered hostnames
+func (app *Application) SyncMirror() error {
+	app.stateMu.RLock()
+	cli, cliCtx := app.cli, app.cliCtx
+	enabled := app.phantomCfg.Mirror.Enabled
+	app.stateMu.RUnlock()
+
+	var hostnames []string
+	if enabled {
+		if cli == nil {
+			return nil
+		}
+		var err error
+		if hostnames, err = cli.GetRegisteredHostnames(cliCtx); err != nil {
+			return fmt.Errorf("failed to list registered hostnames: %w", err)
+		}
+	}
+
+	app.stateMu.Lock()
+	defer app.stateMu.Unlock()
+
+	// the client or the config changed while listing, which queued another sync
+	if app.cli != cli || app.phantomCfg.Mirror.Enabled != enabled {
+		return nil
+	}
+	return app.reconcileMirror(hostnames)
+}
+
+// app.stateMu must be held
+func (app *Application) getMirrorMapping() []MirrorEntry {
+	entries := make([]MirrorEntry, 0)
+	for _, l := range app.phantomCfg.Listeners {
+		if !l.Mirrored {
+			continue
+		}
+		e := MirrorEntry{
+			Hostname: l.Hostname,
+			Listen:   l.Listen,
+		}
+		if f, ok := app.forwarders.Load(l.Listen); ok {
+			e.Address = f.bound
+			e.Running = true
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func (app *Application) GetMirrorMapping() []MirrorEntry {
+	app.stateMu.RLock()
+	defer app.stateMu.RUnlock()
+
+	return app.getMirrorMapping()
+}
@@ -0,0 +1,45 @@
+package phantom
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestMirroredForwarderTakenPort(t *testing.T) {
+	// another process took the port after it was suggested
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	app := &Application{appCtx: context.Background()}
+	l := Listener{
+		Listen:   taken.Addr().String(),
+		Hostname: "app.example.com",
+		Mirrored: true,
+	}
+	acl, err := newAccessList(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := app.getNewForwarder(zap.NewNop(), l, acl)
+	if err != nil {
+		t.Fatalf("expected the mirrored forwarder to move to another port, got %v", err)
+	}
+	defer f.stop()
+
+	if f.cfg.Listen == l.Listen || f.cfg.Listen != f.bound {
+		t.Fatalf("expected the forwarder to listen on the bound address %s, got %s", f.bound, f.cfg.Listen)
+	}
+
+	// forwarders configured by hand keep failing on a taken port
+	l.Mirrored = false
+	if _, err := app.getNewForwarder(zap.NewNop(), l, acl); err == nil {
+		t.Fatal("expected the forwarder to fail on a taken port")
+	}
+}
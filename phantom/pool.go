@@ -0,0 +1,153 @@
+package phantom
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"kon.nect.sh/specter/tun/client/dialer"
+
+	"go.uber.org/zap"
+)
+
+// gatewayPool shares gateway connections between forwarders dialing the same
+// hostname over the same transport. The gateway picks the tunnel from the TLS
+// server name of a connection, so connections cannot be shared across hostnames.
+type gatewayPool struct {
+	mu      sync.Mutex
+	parent  context.Context
+	logger  *zap.Logger
+	memo    *transportMemo
+	proxy   atomic.Pointer[proxyDialer]
+	entries map[string]*pooledGateway
+}
+
+type pooledGateway struct {
+	key    string
+	refs   int
+	ready  chan struct{}
+	err    error
+	cancel context.CancelFunc
+	gw     gatewayConn
+}
+
+func newGatewayPool(parent context.Context, logger *zap.Logger, memo *transportMemo) *gatewayPool {
+	return &gatewayPool{
+		parent:  parent,
+		logger:  logger,
+		memo:    memo,
+		entries: make(map[string]*pooledGateway),
+	}
+}
+
+func (p *gatewayPool) setProxy(d *proxyDialer) {
+	p.proxy.Store(d)
+}
+
+func (p *gatewayPool) options() gatewayOptions {
+	return gatewayOptions{
+		memo:  p.memo,
+		proxy: p.proxy.Load(),
+	}
+}
+
+func poolKey(l Listener) string {
+	return fmt.Sprintf("%s/%s/%t", l.transport(), strings.ToLower(l.Hostname), l.Insecure)
+}
+
+// acquire returns a shared gateway connection for the listener. The reference
+// is released when ctx is done, and the connection is closed with the last reference.
+func (p *gatewayPool) acquire(ctx context.Context, l Listener) (gatewayConn, error) {
+	key := poolKey(l)
+
+	p.mu.Lock()
+	e, ok := p.entries[key]
+	if !ok {
+		gwCtx, gwCancel := context.WithCancel(p.parent)
+		e = &pooledGateway{
+			key:    key,
+			ready:  make(chan struct{}),
+			cancel: gwCancel,
+		}
+		p.entries[key] = e
+		go p.open(gwCtx, e, l)
+	}
+	e.refs++
+	p.mu.Unlock()
+
+	select {
+	case <-e.ready:
+	case <-ctx.Done():
+		p.release(e)
+		return gatewayConn{}, ctx.Err()
+	}
+
+	if e.err != nil {
+		p.release(e)
+		return gatewayConn{}, e.err
+	}
+
+	go func() {
+		<-ctx.Done()
+		p.release(e)
+	}()
+
+	return e.gw, nil
+}
+
+func (p *gatewayPool) open(ctx context.Context, e *pooledGateway, l Listener) {
+	defer close(e.ready)
+
+	e.gw, e.err = dialGateway(ctx, p.logger.With(zap.String("gateway", e.key)), p.options(), l)
+	if e.err != nil {
+		p.mu.Lock()
+		if cur, ok := p.entries[e.key]; ok && cur == e {
+			delete(p.entries, e.key)
+		}
+		p.mu.Unlock()
+		return
+	}
+
+	p.logger.Debug("Opened pooled gateway connection", zap.String("gateway", e.key), zap.String("via", e.gw.remote.String()), zap.String("transport", e.gw.transport))
+}
+
+func (p *gatewayPool) release(e *pooledGateway) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e.refs--
+	if e.refs > 0 {
+		return
+	}
+	if cur, ok := p.entries[e.key]; ok && cur == e {
+		delete(p.entries, e.key)
+	}
+	e.cancel()
+	p.logger.Debug("Released pooled gateway connection", zap.String("gateway", e.key))
+}
+
+// invalidate stops handing out a broken gateway connection. Existing holders
+// keep their reference until they re-dial.
+func (p *gatewayPool) invalidate(td dialer.TransportDialer) {
+	if td == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, e := range p.entries {
+		if e.gw.dialer == td {
+			delete(p.entries, key)
+		}
+	}
+}
+
+func (p *gatewayPool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.entries)
+}
@@ -0,0 +1,159 @@
+package phantom
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// parseListen accepts host:port, host:0 for any free port, or host:low-high for
+// the first free port within the range
+func parseListen(listen string) (host string, low, high int, err error) {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("invalid listen address: %w", err)
+	}
+
+	lowStr, highStr, isRange := strings.Cut(port, "-")
+	if low, err = strconv.Atoi(lowStr); err != nil {
+		return "", 0, 0, fmt.Errorf("invalid port %q", lowStr)
+	}
+	high = low
+	if isRange {
+		if high, err = strconv.Atoi(highStr); err != nil {
+			return "", 0, 0, fmt.Errorf("invalid port %q", highStr)
+		}
+		if low == 0 || high < low {
+			return "", 0, 0, fmt.Errorf("invalid port range %s", port)
+		}
+	}
+	if low < 0 || high > 65535 {
+		return "", 0, 0, fmt.Errorf("port out of range")
+	}
+
+	return host, low, high, nil
+}
+
+func isEphemeralListen(listen string) bool {
+	_, low, high, err := parseListen(listen)
+	return err == nil && (low == 0 || low != high)
+}
+
+func listenLocal(listen string) (net.Listener, error) {
+	host, low, high, err := parseListen(listen)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for port := low; port <= high; port++ {
+		l, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err == nil {
+			return l, nil
+		}
+		lastErr = err
+	}
+	if low != high {
+		return nil, fmt.Errorf("no free port in range %d-%d: %w", low, high, lastErr)
+	}
+	return nil, lastErr
+}
+
+// app.stateMu must be held
+func (app *Application) isListenConfigured(listen string) bool {
+	for _, l := range app.phantomCfg.Listeners {
+		if l.Listen == listen {
+			return true
+		}
+	}
+	return false
+}
+
+// app.stateMu must be held
+func (app *Application) checkListenAddress(listen string) error {
+	if _, _, _, err := parseListen(listen); err != nil {
+		return err
+	}
+
+	if app.isListenConfigured(listen) {
+		return fmt.Errorf("listener with address %s already exists", listen)
+	}
+
+	if isEphemeralListen(listen) {
+		return nil
+	}
+
+	conflict := false
+	app.forwarders.Range(func(_ string, f *forwarder) bool {
+		if f.bound == listen {
+			conflict = true
+			return false
+		}
+		return true
+	})
+	if conflict {
+		return fmt.Errorf("address %s is in use by another forwarder", listen)
+	}
+
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		return fmt.Errorf("address %s is not available: %w", listen, err)
+	}
+	l.Close()
+
+	return nil
+}
+
+// app.stateMu must be held
+func (app *Application) suggestListenAddress(host string) (string, error) {
+	if host == "" {
+		host = "127.0.0.1"
+	}
+
+	// try a few times in case the port picked by the OS collides with a configured listener
+	for i := 0; i < 10; i++ {
+		l, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+		if err != nil {
+			return "", err
+		}
+		addr := l.Addr().String()
+		l.Close()
+
+		if !app.isListenConfigured(addr) {
+			return addr, nil
+		}
+	}
+
+	return "", fmt.Errorf("unable to find a free port on %s", host)
+}
+
+func (app *Application) CheckListenAddress(listen string) error {
+	app.stateMu.RLock()
+	defer app.stateMu.RUnlock()
+
+	return app.checkListenAddress(listen)
+}
+
+func (app *Application) SuggestListenAddress(host string) (string, error) {
+	app.stateMu.RLock()
+	defer app.stateMu.RUnlock()
+
+	return app.suggestListenAddress(host)
+}
+
+// app.stateMu must be held
+func (app *Application) persistBoundAddress(spec string, f *forwarder) error {
+	if spec == f.cfg.Listen {
+		return nil
+	}
+
+	for i, l := range app.phantomCfg.Listeners {
+		if l.Listen == spec {
+			app.phantomCfg.Listeners[i].Listen = f.cfg.Listen
+			return app.persistPhantomConfig(app.phantomCfg)
+		}
+	}
+
+	return nil
+}
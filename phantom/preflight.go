@@ -0,0 +1,127 @@
+package phantom
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"kon.nect.sh/specter/tun/client/dialer"
+
+	"go.uber.org/zap"
+)
+
+const preflightTimeout = time.Second * 15
+
+type PreflightStep struct {
+	Name     string `json:"name"`
+	Success  bool   `json:"success"`
+	Detail   string `json:"detail,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Duration int64  `json:"duration"` // milliseconds
+}
+
+type PreflightReport struct {
+	Hostname string          `json:"hostname"`
+	Success  bool            `json:"success"`
+	Steps    []PreflightStep `json:"steps"`
+}
+
+func (r *PreflightReport) run(name string, fn func() (string, error)) bool {
+	start := time.Now()
+	detail, err := fn()
+	step := PreflightStep{
+		Name:     name,
+		Success:  err == nil,
+		Detail:   detail,
+		Duration: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		step.Error = err.Error()
+	}
+	r.Steps = append(r.Steps, step)
+	return err == nil
+}
+
+// TestForwarder checks that a forwarder would be able to reach its tunnel, without
+// binding the local address or touching the config. Note that the stream step
+// does reach the published target on the other end of the tunnel.
+func (app *Application) TestForwarder(l Listener) PreflightReport {
+	report := PreflightReport{
+		Hostname: l.Hostname,
+		Steps:    make([]PreflightStep, 0),
+	}
+
+	ctx, cancel := context.WithTimeout(app.appCtx, preflightTimeout)
+	defer cancel()
+
+	var (
+		parsed *dialer.ParsedApex
+		gw     gatewayConn
+	)
+
+	if !report.run("parse", func() (string, error) {
+		var err error
+		parsed, err = dialer.ParseApex(l.Hostname)
+		if err != nil {
+			return "", err
+		}
+		return parsed.String(), nil
+	}) {
+		return report
+	}
+
+	if !report.run("resolve", func() (string, error) {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, parsed.Host)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%v", addrs), nil
+	}) {
+		return report
+	}
+
+	// the dialer context lives until the end of the test and tears down the connection
+	if !report.run("handshake", func() (string, error) {
+		var err error
+		gw, err = dialGateway(ctx, app.logger.With(zap.String("preflight", l.Hostname)), app.gateways.options(), l)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s over %s", gw.remote.String(), gw.transport), nil
+	}) {
+		return report
+	}
+
+	if !report.run("stream", func() (string, error) {
+		type result struct {
+			conn net.Conn
+			err  error
+		}
+		done := make(chan result, 1)
+		go func() {
+			conn, err := gw.dialer.Dial()
+			done <- result{conn, err}
+		}()
+		select {
+		case r := <-done:
+			if r.err != nil {
+				return "", r.err
+			}
+			r.conn.Close()
+			return "tunnel accepted the connection", nil
+		case <-ctx.Done():
+			go func() {
+				if r := <-done; r.conn != nil {
+					r.conn.Close()
+				}
+			}()
+			return "", fmt.Errorf("timed out opening stream: %w", ctx.Err())
+		}
+	}) {
+		return report
+	}
+
+	report.Success = true
+	return report
+}
@@ -0,0 +1,279 @@
+package phantom
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"kon.nect.sh/specter/spec/protocol"
+	"kon.nect.sh/specter/spec/tun"
+	"kon.nect.sh/specter/tun/client/dialer"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+const (
+	ProxyModeNone        = ""
+	ProxyModeEnvironment = "environment"
+	ProxyModeURL         = "url"
+)
+
+type ProxyConfig struct {
+	Mode        string `json:"mode"`
+	URL         string `json:"url,omitempty"`
+	Username    string `json:"username,omitempty"`
+	Password    string `json:"password,omitempty"`
+	HasPassword bool   `json:"hasPassword,omitempty"` // set instead of Password when sent to the UI
+}
+
+// redacted returns the config without its password, for the UI
+func (c ProxyConfig) redacted() ProxyConfig {
+	c.HasPassword = c.Password != ""
+	c.Password = ""
+	return c
+}
+
+// withSavedPassword keeps the saved password when the UI, which never sees it,
+// sends the config back for the same user without a new one
+func (c ProxyConfig) withSavedPassword(saved ProxyConfig) ProxyConfig {
+	if c.Password == "" && c.Username != "" && c.Username == saved.Username {
+		c.Password = saved.Password
+	}
+	c.HasPassword = false
+	return c
+}
+
+// proxyDialer connects to the gateway through an outbound HTTP(S) or SOCKS5 proxy.
+// Only the TCP transport can be proxied, QUIC always goes out directly.
+type proxyDialer struct {
+	fixed    *url.URL
+	username string
+	password string
+}
+
+func newProxyDialer(cfg ProxyConfig) (*proxyDialer, error) {
+	p := &proxyDialer{
+		username: cfg.Username,
+		password: cfg.Password,
+	}
+	switch cfg.Mode {
+	case ProxyModeNone:
+		return nil, nil
+	case ProxyModeEnvironment:
+		return p, nil
+	case ProxyModeURL:
+		u, err := url.Parse(cfg.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url: %w", err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme. valid schemes: http, https, socks5; got %s", u.Scheme)
+		}
+		if u.Host == "" {
+			return nil, fmt.Errorf("missing host in proxy url")
+		}
+		p.fixed = u
+		return p, nil
+	default:
+		return nil, fmt.Errorf("unknown proxy mode %q", cfg.Mode)
+	}
+}
+
+// proxyFor returns nil if addr should be dialed directly
+func (p *proxyDialer) proxyFor(addr string) (*url.URL, error) {
+	u := p.fixed
+	if u == nil {
+		var err error
+		u, err = httpproxy.FromEnvironment().ProxyFunc()(&url.URL{Scheme: "https", Host: addr})
+		if err != nil || u == nil {
+			return nil, err
+		}
+	}
+	if u.User == nil && p.username != "" {
+		c := *u
+		c.User = url.UserPassword(p.username, p.password)
+		u = &c
+	}
+	return u, nil
+}
+
+func (p *proxyDialer) DialContext(ctx context.Context, addr string) (net.Conn, error) {
+	u, err := p.proxyFor(addr)
+	if err != nil {
+		return nil, fmt.Errorf("error finding proxy: %w", err)
+	}
+
+	var d net.Dialer
+	if u == nil {
+		return d.DialContext(ctx, "tcp", addr)
+	}
+
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		sd, err := proxy.FromURL(u, &d)
+		if err != nil {
+			return nil, err
+		}
+		if cd, ok := sd.(proxy.ContextDialer); ok {
+			return cd.DialContext(ctx, "tcp", addr)
+		}
+		return sd.Dial("tcp", addr)
+	default:
+		return connectViaHTTP(ctx, &d, u, addr)
+	}
+}
+
+func connectViaHTTP(ctx context.Context, d *net.Dialer, u *url.URL, addr string) (net.Conn, error) {
+	proxyAddr := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "https" {
+			proxyAddr = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			proxyAddr = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	conn, err := d.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to proxy: %w", err)
+	}
+	if u.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error sending CONNECT to proxy: %w", err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error reading CONNECT response from proxy: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused CONNECT: %s", resp.Status)
+	}
+
+	return conn, nil
+}
+
+type proxiedAddr string
+
+func (a proxiedAddr) Network() string { return "tcp" }
+func (a proxiedAddr) String() string  { return string(a) + " (via proxy)" }
+
+// proxiedTLSDialer mirrors dialer.TLSDialer, except the TCP connection to the
+// gateway is established through the proxy
+type proxiedTLSDialer struct {
+	ctx    context.Context
+	proxy  *proxyDialer
+	addr   string
+	tlsCfg *tls.Config
+}
+
+var _ dialer.TransportDialer = (*proxiedTLSDialer)(nil)
+
+// newProxiedTLSDialer goes through the proxy and handshakes with the gateway once
+// up front, so a refusing proxy or gateway fails the forwarder rather than its first connection
+func newProxiedTLSDialer(ctx context.Context, p *proxyDialer, cfg dialer.DialerConfig) (*proxiedTLSDialer, error) {
+	d := &proxiedTLSDialer{
+		ctx:   ctx,
+		proxy: p,
+		addr:  cfg.Parsed.String(),
+		tlsCfg: &tls.Config{
+			ServerName:         cfg.Parsed.Host,
+			InsecureSkipVerify: cfg.InsecureSkipVerify,
+			NextProtos: []string{
+				tun.ALPN(protocol.Link_TCP),
+			},
+		},
+	}
+	conn, err := d.handshake()
+	if err != nil {
+		return nil, err
+	}
+	conn.Close()
+	return d, nil
+}
+
+func (d *proxiedTLSDialer) Remote() net.Addr {
+	return proxiedAddr(d.addr)
+}
+
+func (d *proxiedTLSDialer) handshake() (*tls.Conn, error) {
+	raw, err := d.proxy.DialContext(d.ctx, d.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := tls.Client(raw, d.tlsCfg)
+	if err := conn.HandshakeContext(d.ctx); err != nil {
+		raw.Close()
+		return nil, fmt.Errorf("error handshaking with gateway: %w", err)
+	}
+	return conn, nil
+}
+
+func (d *proxiedTLSDialer) Dial() (net.Conn, error) {
+	conn, err := d.handshake()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tun.DrainStatusProto(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func (app *Application) applyProxyConfig(cfg ProxyConfig) error {
+	p, err := newProxyDialer(cfg)
+	if err != nil {
+		return err
+	}
+	app.gateways.setProxy(p)
+	return nil
+}
+
+func proxyDescription(cfg ProxyConfig) string {
+	switch cfg.Mode {
+	case ProxyModeURL:
+		u, err := url.Parse(cfg.URL)
+		if err != nil {
+			return "invalid"
+		}
+		return u.Redacted()
+	default:
+		return cfg.Mode
+	}
+}
@@ -0,0 +1,87 @@
+package phantom
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProxyConfigPassword(t *testing.T) {
+	saved := ProxyConfig{Mode: ProxyModeURL, URL: "http://proxy:3128", Username: "alice", Password: "secret"}
+
+	redacted := saved.redacted()
+	if redacted.Password != "" || !redacted.HasPassword {
+		t.Fatalf("expected the password to be replaced by a flag, got %+v", redacted)
+	}
+
+	cases := []struct {
+		name     string
+		incoming ProxyConfig
+		expected string
+	}{
+		{
+			name:     "sent back unchanged",
+			incoming: redacted,
+			expected: "secret",
+		},
+		{
+			name:     "new password",
+			incoming: ProxyConfig{Mode: ProxyModeURL, URL: "http://proxy:3128", Username: "alice", Password: "changed"},
+			expected: "changed",
+		},
+		{
+			name:     "different user",
+			incoming: ProxyConfig{Mode: ProxyModeURL, URL: "http://proxy:3128", Username: "bob"},
+		},
+		{
+			name:     "user removed",
+			incoming: ProxyConfig{Mode: ProxyModeURL, URL: "http://proxy:3128"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.incoming.withSavedPassword(saved)
+			if got.Password != tc.expected {
+				t.Fatalf("expected password %q, got %q", tc.expected, got.Password)
+			}
+			if got.HasPassword {
+				t.Fatal("expected the UI flag to be dropped before saving")
+			}
+		})
+	}
+}
+
+func TestPersistPhantomConfigPermissions(t *testing.T) {
+	prev := phantomConfigFile
+	phantomConfigFile = filepath.Join(t.TempDir(), "phantom.json")
+	t.Cleanup(func() { phantomConfigFile = prev })
+
+	// a config written before the password was stored
+	if err := os.WriteFile(phantomConfigFile, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	app := &Application{}
+	cfg := &PhantomConfig{Proxy: ProxyConfig{Mode: ProxyModeEnvironment, Username: "alice", Password: "secret"}}
+	if err := app.persistPhantomConfig(cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	info, err := os.Stat(phantomConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Fatalf("expected the config to be readable by the owner only, got %o", perm)
+	}
+
+	data, err := os.ReadFile(phantomConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "hasPassword") {
+		t.Fatal("expected the UI flag not to be persisted")
+	}
+}
@@ -0,0 +1,425 @@
+package phantom
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"kon.nect.sh/phantom/internal/configdir"
+
+	"kon.nect.sh/specter/overlay"
+	rttImpl "kon.nect.sh/specter/rtt"
+	"kon.nect.sh/specter/spec/protocol"
+	"kon.nect.sh/specter/spec/tun"
+	"kon.nect.sh/specter/tun/client"
+	"kon.nect.sh/specter/tun/client/connector"
+	"kon.nect.sh/specter/tun/client/dialer"
+
+	"go.uber.org/zap"
+)
+
+const runReleaseTimeout = time.Second * 10
+
+const runUsage = "usage: phantom run [--forward name=hostname]... [--publish target]... [flags] -- command [args...]"
+
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// envName turns a forwarder name into the middle part of its environment variables
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+// RunProcess starts forwarders and publishes tunnels for the lifetime of a child
+// command. Forwarder addresses are passed to the child as PHANTOM_<NAME>_ADDR,
+// _HOST and _PORT, and published hostnames as PHANTOM_PUBLISH_<N>_HOSTNAME.
+// Everything is torn down when the child exits, and its exit code is returned.
+func RunProcess(args []string) int {
+	var forwards, publishes stringList
+
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, runUsage)
+		fs.PrintDefaults()
+	}
+	fs.Var(&forwards, "forward", "start a forwarder as name=hostname, can be repeated")
+	fs.Var(&publishes, "publish", "publish a tunnel to target for the duration of the command, can be repeated")
+	insecure := fs.Bool("insecure", false, "skip verifying the certificate of the gateway for forwarders")
+	useTCP := fs.Bool("tcp", false, "connect forwarders to the gateway over TLS/TCP instead of QUIC")
+	configDir := fs.String("config", configdir.LocalConfig("phantom"), "phantom config directory, used for the proxy settings and to publish tunnels")
+	verbose := fs.Bool("verbose", false, "log to stderr")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	command := fs.Args()
+	if len(command) == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	logger := cliLogger(*verbose)
+	defer logger.Sync()
+
+	setConfigPath(*configDir)
+	phantomCfg, err := loadPhantomConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "phantom: %v\n", err)
+		return 1
+	}
+	proxy, err := newProxyDialer(phantomCfg.Proxy)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "phantom: invalid proxy configuration: %v\n", err)
+		return 1
+	}
+
+	type forward struct {
+		name     string
+		hostname string
+	}
+	parsed := make([]forward, 0, len(forwards))
+	for _, spec := range forwards {
+		name, hostname, ok := strings.Cut(spec, "=")
+		if !ok || name == "" || hostname == "" {
+			fmt.Fprintf(os.Stderr, "phantom: invalid forward %q, expected name=hostname\n", spec)
+			return 2
+		}
+		parsed = append(parsed, forward{name: name, hostname: hostname})
+	}
+
+	// installed before setup, so an interrupt while forwarding or publishing still
+	// releases what was published so far
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var interrupted os.Signal
+	setupDone := make(chan struct{})
+	watched := make(chan struct{})
+	go func() {
+		defer close(watched)
+		select {
+		case interrupted = <-sigs:
+			cancel()
+		case <-setupDone:
+		}
+	}()
+
+	env := os.Environ()
+	opts := gatewayOptions{
+		memo:  newTransportMemo(),
+		proxy: proxy,
+	}
+	var publisher *runPublisher
+
+	setup := func() error {
+		for _, f := range parsed {
+			addr, err := runForwarder(ctx, logger, opts, Listener{
+				Label:    f.name,
+				Listen:   "127.0.0.1:0",
+				Hostname: f.hostname,
+				Insecure: *insecure,
+				UseTCP:   *useTCP,
+			})
+			if err != nil {
+				return err
+			}
+			host, port, _ := net.SplitHostPort(addr)
+			key := "PHANTOM_" + envName(f.name)
+			env = append(env, key+"_ADDR="+addr, key+"_HOST="+host, key+"_PORT="+port)
+			fmt.Fprintf(os.Stderr, "phantom: forwarding %s to %s\n", addr, f.hostname)
+		}
+
+		if len(publishes) == 0 {
+			return nil
+		}
+		if phantomCfg.Proxy.Mode != ProxyModeNone {
+			fmt.Fprintln(os.Stderr, "phantom: publishing connects over QUIC, which cannot use the configured proxy")
+		}
+		p, err := publishTunnels(ctx, logger, phantomCfg, publishes)
+		if err != nil {
+			return err
+		}
+		publisher = p
+
+		for i, t := range p.tunnels {
+			env = append(env, fmt.Sprintf("PHANTOM_PUBLISH_%d_HOSTNAME=%s", i+1, t.Hostname))
+			fmt.Fprintf(os.Stderr, "phantom: published %s at %s\n", t.Target, t.Hostname)
+		}
+		return nil
+	}
+
+	err = setup()
+	close(setupDone)
+	<-watched
+	if publisher != nil {
+		defer publisher.close()
+	}
+
+	if interrupted != nil {
+		fmt.Fprintln(os.Stderr, "phantom: interrupted")
+		return signalExitCode(interrupted)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "phantom: %v\n", err)
+		return 1
+	}
+
+	return runChild(command, env, sigs)
+}
+
+func runForwarder(ctx context.Context, logger *zap.Logger, opts gatewayOptions, l Listener) (string, error) {
+	listener, err := listenLocal(l.Listen)
+	if err != nil {
+		return "", fmt.Errorf("error listening locally: %w", err)
+	}
+
+	logger = logger.With(zap.Object("listener", &l))
+	d := newManagedDialer(ctx, func(ctx context.Context) (gatewayConn, error) {
+		return dialGateway(ctx, logger, opts, l)
+	})
+	if _, err := d.connect(); err != nil {
+		listener.Close()
+		return "", fmt.Errorf("error dialing specter gateway for %s: %w", l.Hostname, err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+		d.close()
+	}()
+	go connector.HandleConnections(logger, listener, d)
+
+	return listener.Addr().String(), nil
+}
+
+type runPublisher struct {
+	cli       *client.Client
+	cancel    context.CancelFunc
+	transport *overlay.QUIC
+	tunnels   []client.Tunnel
+	cleanup   func()
+}
+
+// ephemeralSpecterConfig creates a client config with an identity of its own for apex, in a
+// temporary directory removed by cleanup. Registering with the identity of the app instead
+// would take its place with the specter gateway while both are running.
+func ephemeralSpecterConfig(apex string) (cfg *client.Config, cleanup func(), err error) {
+	dir, err := os.MkdirTemp("", "phantom-run-")
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating temporary specter config: %w", err)
+	}
+	cleanup = func() {
+		os.RemoveAll(dir)
+	}
+
+	path := filepath.Join(dir, "specter.yaml")
+	if err := os.WriteFile(path, []byte(fmt.Sprintf("apex: %s\n", apex)), 0600); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("error creating temporary specter config: %w", err)
+	}
+	cfg, err = client.NewConfig(path)
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("error loading temporary specter config: %w", err)
+	}
+	return cfg, cleanup, nil
+}
+
+// publishTunnels connects a specter client with a throwaway identity for the apex
+// configured in the app, and publishes a tunnel for every target
+func publishTunnels(ctx context.Context, logger *zap.Logger, phantomCfg *PhantomConfig, targets []string) (*runPublisher, error) {
+	for _, t := range targets {
+		if err := (&Helper{}).ValidateTarget(t); err != nil {
+			return nil, fmt.Errorf("invalid publish target %s: %w", t, err)
+		}
+	}
+
+	appCfg, err := client.NewConfig(specterConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading specter config: %w", err)
+	}
+	if appCfg.Apex == "" {
+		return nil, fmt.Errorf("apex is not configured in %s", specterConfigFile)
+	}
+	parsed, err := dialer.ParseApex(appCfg.Apex)
+	if err != nil {
+		return nil, err
+	}
+
+	specterCfg, cleanup, err := ephemeralSpecterConfig(appCfg.Apex)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range targets {
+		specterCfg.Tunnels = append(specterCfg.Tunnels, client.Tunnel{Target: t})
+	}
+
+	recorder := rttImpl.NewInstrumentation(20)
+	transport := overlay.NewQUIC(overlay.TransportConfig{
+		Logger: logger,
+		Endpoint: &protocol.Node{
+			Id: specterCfg.ClientID,
+		},
+		ClientTLS: &tls.Config{
+			ServerName:         parsed.Host,
+			InsecureSkipVerify: phantomCfg.SpecterInsecureSkipVerify,
+			NextProtos: []string{
+				tun.ALPN(protocol.Link_SPECTER_TUN),
+			},
+		},
+		RTTRecorder: recorder,
+	})
+
+	// the client outlives an interrupted setup, so the tunnels it published can still be released
+	cliCtx, cliCancel := context.WithCancel(context.Background())
+	fail := func(err error) (*runPublisher, error) {
+		cliCancel()
+		transport.Stop()
+		cleanup()
+		return nil, err
+	}
+
+	c, err := client.NewClient(cliCtx, client.ClientConfig{
+		Logger:          logger,
+		Configuration:   specterCfg,
+		ServerTransport: transport,
+		Recorder:        recorder,
+	})
+	if err != nil {
+		return fail(err)
+	}
+	if err := c.Register(ctx); err != nil {
+		return fail(fmt.Errorf("error registering specter client: %w", err))
+	}
+
+	p := &runPublisher{
+		cli:       c,
+		cancel:    cliCancel,
+		transport: transport,
+		cleanup:   cleanup,
+	}
+	if err := c.Initialize(ctx); err != nil {
+		// tunnels published before the error are released again
+		p.tunnels = c.GetCurrentConfig().Tunnels
+		p.close()
+		return nil, fmt.Errorf("error publishing tunnels: %w", err)
+	}
+	c.Start(cliCtx)
+
+	p.tunnels = c.GetCurrentConfig().Tunnels
+	return p, nil
+}
+
+// close releases the tunnels published for the command, so their hostnames do not linger with the gateway
+func (p *runPublisher) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), runReleaseTimeout)
+	defer cancel()
+
+	for _, t := range p.tunnels {
+		if t.Hostname == "" {
+			continue
+		}
+		if err := p.cli.ReleaseTunnel(ctx, t); err != nil {
+			fmt.Fprintf(os.Stderr, "phantom: failed to release tunnel %s: %v\n", t.Hostname, err)
+		}
+	}
+	p.cli.Close()
+	p.cancel()
+	p.transport.Stop()
+	p.cleanup()
+}
+
+// runChild runs the command with signals from sigs forwarded to it, and returns its exit code
+func runChild(command []string, env []string, sigs <-chan os.Signal) int {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// interrupted after setup, but before there was a child to receive it
+	select {
+	case sig := <-sigs:
+		fmt.Fprintln(os.Stderr, "phantom: interrupted")
+		return signalExitCode(sig)
+	default:
+	}
+
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "phantom: %v\n", err)
+		return 127
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-sigs:
+				if sig == os.Interrupt {
+					// the child shares our process group, so an interrupt from the terminal already reached it
+					continue
+				}
+				if err := cmd.Process.Signal(sig); err != nil {
+					cmd.Process.Kill()
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err := cmd.Wait()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		fmt.Fprintf(os.Stderr, "phantom: %v\n", err)
+		return 1
+	}
+
+	if code := cmd.ProcessState.ExitCode(); code >= 0 {
+		return code
+	}
+	if ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return signalExitCode(ws.Signal())
+	}
+	return 1
+}
+
+// signalExitCode is the exit code of a shell for a process terminated by sig
+func signalExitCode(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 1
+}
@@ -0,0 +1,401 @@
+package phantom
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"kon.nect.sh/phantom/internal/schedule"
+
+	"kon.nect.sh/specter/tun/client"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"go.uber.org/zap"
+)
+
+// scheduleWakeThreshold is how late a tick has to be to assume the machine was asleep
+const scheduleWakeThreshold = time.Minute * 2
+
+// TunnelMetadata holds the settings phantom keeps for a published tunnel,
+// which the specter client config has no room for
+type TunnelMetadata struct {
+	Hostname string `json:"hostname"`
+	Schedule string `json:"schedule,omitempty"`
+	TTL      int    `json:"ttl,omitempty"` // minutes after publishing before the tunnel is unpublished
+}
+
+type ScheduleChange struct {
+	Kind   string `json:"kind"` // forwarder or tunnel
+	Name   string `json:"name"` // listen address or hostname
+	Active bool   `json:"active"`
+	Error  string `json:"error,omitempty"`
+}
+
+func validSchedule(expr string) error {
+	if expr == "" {
+		return nil
+	}
+	_, err := schedule.Parse(expr)
+	return err
+}
+
+// scheduleActive reports whether expr is active at now, which is always the case without a valid schedule
+func scheduleActive(expr string, now time.Time) bool {
+	if expr == "" {
+		return true
+	}
+	s, err := schedule.Parse(expr)
+	return err != nil || s.Active(now)
+}
+
+// scheduler calls reconcile on every minute boundary, and resumed before that when
+// the boundary came late enough to assume the machine was asleep
+type scheduler struct {
+	clock     schedule.Clock
+	reconcile func(now time.Time)
+	resumed   func(gap time.Duration)
+}
+
+func (s *scheduler) run(ctx context.Context) {
+	last := s.clock.Now()
+	s.reconcile(last)
+
+	for {
+		now := s.clock.Now()
+		wait := now.Truncate(time.Minute).Add(time.Minute).Sub(now)
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.clock.After(wait):
+		}
+
+		now = s.clock.Now()
+		// compare wall clock readings, the monotonic clock may not advance during sleep
+		if gap := now.Round(0).Sub(last.Round(0)); gap > scheduleWakeThreshold {
+			s.resumed(gap)
+		}
+		last = now
+
+		s.reconcile(now)
+	}
+}
+
+// runScheduler applies the schedules of forwarders and tunnels on every minute
+// boundary. State is only changed when a schedule flips, so starting or stopping
+// something by hand within a window sticks until the next boundary. After the
+// machine wakes up, everything is brought in line with the current time; windows
+// that opened and closed entirely during sleep are skipped.
+func (app *Application) runScheduler(ctx context.Context, clock schedule.Clock) {
+	app.seedSchedules(clock.Now())
+
+	s := &scheduler{
+		clock:     clock,
+		reconcile: app.reconcileSchedules,
+		resumed: func(gap time.Duration) {
+			app.logger.Info("Scheduler resumed after a gap", zap.Duration("gap", gap))
+			runtime.EventsEmit(app.appCtx, "schedule:Resumed", gap.Milliseconds())
+		},
+	}
+	s.run(ctx)
+}
+
+func (app *Application) reconcileSchedules(now time.Time) {
+	app.stateMu.Lock()
+	defer app.stateMu.Unlock()
+
+	changes := app.reconcileForwarderSchedules(now)
+	changes = append(changes, app.reconcileTunnelSchedules(now)...)
+
+	for _, c := range changes {
+		runtime.EventsEmit(app.appCtx, "schedule:Changed", c)
+	}
+}
+
+// seedSchedules records the current state of every schedule without acting on it, so
+// whatever was started or stopped at launch stays that way until a schedule flips
+func (app *Application) seedSchedules(now time.Time) {
+	app.stateMu.Lock()
+	defer app.stateMu.Unlock()
+
+	for _, l := range app.phantomCfg.Listeners {
+		if s, err := schedule.Parse(l.Schedule); l.Schedule != "" && err == nil {
+			app.scheduled["forwarder:"+l.Listen] = s.Active(now)
+		}
+	}
+	for _, m := range app.phantomCfg.TunnelMeta {
+		if s, err := schedule.Parse(m.Schedule); m.Schedule != "" && err == nil {
+			app.scheduled["tunnel:"+strings.ToLower(m.Hostname)] = s.Active(now)
+		}
+	}
+}
+
+// scheduleFlipped records the desired state of key, and reports whether it
+// differs from the previous evaluation
+// app.stateMu must be held
+func (app *Application) scheduleFlipped(key string, active bool) bool {
+	prev, seen := app.scheduled[key]
+	app.scheduled[key] = active
+	return !seen || prev != active
+}
+
+// app.stateMu must be held
+func (app *Application) reconcileForwarderSchedules(now time.Time) []ScheduleChange {
+	changes := make([]ScheduleChange, 0)
+	for _, l := range app.phantomCfg.Listeners {
+		if l.Schedule == "" {
+			continue
+		}
+		s, err := schedule.Parse(l.Schedule)
+		if err != nil {
+			app.logger.Debug("Ignoring invalid forwarder schedule", zap.Object("listener", &l), zap.Error(err))
+			continue
+		}
+
+		active := s.Active(now)
+		if !app.scheduleFlipped("forwarder:"+l.Listen, active) {
+			continue
+		}
+
+		change := ScheduleChange{
+			Kind:   "forwarder",
+			Name:   l.Listen,
+			Active: active,
+		}
+		f, running := app.forwarders.Load(l.Listen)
+		switch {
+		case active && !running:
+			app.logger.Info("Starting forwarder on schedule", zap.Object("listener", &l))
+			f, err := app.startForwarder(l)
+			if err != nil {
+				app.logger.Warn("Failed to start forwarder on schedule, will retry", zap.Object("listener", &l), zap.Error(err))
+				runtime.EventsEmit(app.appCtx, "forwarder:Failed", l.Listen, err.Error())
+				app.scheduleRetry(l.Listen)
+				change.Error = err.Error()
+				break
+			}
+			if err := app.persistBoundAddress(l.Listen, f); err != nil {
+				app.logger.Error("Failed to persist bound address", zap.Object("listener", &f.cfg), zap.Error(err))
+			}
+		case !active:
+			app.cancelRetry(l.Listen)
+			if running {
+				app.logger.Info("Stopping forwarder on schedule", zap.Object("listener", &l))
+				app.stopForwarder(l, f)
+				runtime.EventsEmit(app.appCtx, "forwarder:Stopped", l.Listen)
+			}
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// app.stateMu must be held
+func (app *Application) reconcileTunnelSchedules(now time.Time) []ScheduleChange {
+	if app.cli == nil {
+		return nil
+	}
+
+	var (
+		changes = make([]ScheduleChange, 0)
+		opened  = make(map[string]bool)
+		closed  = make(map[string]bool)
+	)
+	for _, m := range app.phantomCfg.TunnelMeta {
+		if m.Schedule == "" {
+			continue
+		}
+		s, err := schedule.Parse(m.Schedule)
+		if err != nil {
+			app.logger.Debug("Ignoring invalid tunnel schedule", zap.String("hostname", m.Hostname), zap.Error(err))
+			continue
+		}
+
+		key := strings.ToLower(m.Hostname)
+		active := s.Active(now)
+		if !app.scheduleFlipped("tunnel:"+key, active) {
+			continue
+		}
+		if active {
+			opened[key] = true
+		} else {
+			closed[key] = true
+		}
+		changes = append(changes, ScheduleChange{
+			Kind:   "tunnel",
+			Name:   m.Hostname,
+			Active: active,
+		})
+	}
+
+	if len(opened) > 0 {
+		// tunnels that are not published stay that way, unless their window opened
+		withheld := app.withheldTunnels(now)
+		for key := range app.unpublishedTunnels(app.cli) {
+			if !opened[key] {
+				withheld[key] = true
+			}
+		}
+		app.logger.Info("Publishing tunnels on schedule", zap.Int("tunnels", len(opened)))
+		app.syncTunnels(app.cli, now, withheld, func() error {
+			app.cli.SyncConfigTunnels(app.cliCtx)
+			return nil
+		})
+	}
+
+	for _, t := range app.cli.GetCurrentConfig().Tunnels {
+		key := strings.ToLower(t.Hostname)
+		if _, published := app.published[key]; !closed[key] || !published {
+			continue
+		}
+		app.logger.Info("Unpublishing tunnel on schedule", zap.String("hostname", t.Hostname))
+		if err := app.cli.UnpublishTunnel(app.appCtx, t); err != nil {
+			app.logger.Warn("Failed to unpublish tunnel on schedule", zap.String("hostname", t.Hostname), zap.Error(err))
+			continue
+		}
+		delete(app.published, key)
+	}
+
+	return changes
+}
+
+// withheldTunnels returns the tunnels that must not be published at now, because
+// they are outside their schedule or their TTL ran out
+// app.stateMu must be held
+func (app *Application) withheldTunnels(now time.Time) map[string]bool {
+	withheld := make(map[string]bool)
+	for key := range app.expired {
+		withheld[key] = true
+	}
+	for _, m := range app.phantomCfg.TunnelMeta {
+		if _, exceeded := app.ttlExceeded(m.Hostname, now); exceeded || !scheduleActive(m.Schedule, now) {
+			withheld[strings.ToLower(m.Hostname)] = true
+		}
+	}
+	return withheld
+}
+
+// syncTunnels runs publish, which publishes every tunnel in the config of c, and then
+// unpublishes the withheld tunnels again. They are never taken out of the config, as
+// the client may persist it while publishing.
+// app.stateMu must be held
+func (app *Application) syncTunnels(c *client.Client, now time.Time, withheld map[string]bool, publish func() error) error {
+	err := publish()
+
+	published := make([]client.Tunnel, 0)
+	for _, t := range c.GetCurrentConfig().Tunnels {
+		if !withheld[strings.ToLower(t.Hostname)] {
+			published = append(published, t)
+			continue
+		}
+		if err := c.UnpublishTunnel(app.appCtx, t); err != nil {
+			app.logger.Warn("Failed to unpublish withheld tunnel", zap.String("hostname", t.Hostname), zap.Error(err))
+		}
+	}
+
+	if err == nil {
+		app.markPublished(now, published)
+	}
+	return err
+}
+
+// unpublishedTunnels returns the tunnels of c that are not published, so that publishing
+// some tunnels on their own can leave these as they are
+// app.stateMu must be held
+func (app *Application) unpublishedTunnels(c *client.Client) map[string]bool {
+	unpublished := make(map[string]bool)
+	for _, t := range c.GetCurrentConfig().Tunnels {
+		key := strings.ToLower(t.Hostname)
+		if _, ok := app.published[key]; !ok {
+			unpublished[key] = true
+		}
+	}
+	return unpublished
+}
+
+// app.stateMu must be held
+func (app *Application) tunnelMeta(hostname string) (int, bool) {
+	for i, m := range app.phantomCfg.TunnelMeta {
+		if strings.EqualFold(m.Hostname, hostname) {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+func (app *Application) UpdateTunnelTTL(hostname string, minutes int) error {
+	app.stateMu.Lock()
+	defer app.stateMu.Unlock()
+
+	if hostname == "" {
+		return fmt.Errorf("hostname cannot be empty")
+	}
+	if minutes < 0 {
+		return fmt.Errorf("ttl cannot be negative")
+	}
+
+	if i, ok := app.tunnelMeta(hostname); ok {
+		app.phantomCfg.TunnelMeta[i].TTL = minutes
+	} else {
+		app.phantomCfg.TunnelMeta = append(app.phantomCfg.TunnelMeta, TunnelMetadata{
+			Hostname: hostname,
+			TTL:      minutes,
+		})
+	}
+
+	if err := app.persistPhantomConfig(app.phantomCfg); err != nil {
+		return fmt.Errorf("failed to persist tunnel metadata: %w", err)
+	}
+
+	return nil
+}
+
+func (app *Application) UpdateForwarderSchedule(index int, expr string) error {
+	app.stateMu.Lock()
+	defer app.stateMu.Unlock()
+
+	l, _, _, err := app.findForwarder(index)
+	if err != nil {
+		return err
+	}
+	if err := validSchedule(expr); err != nil {
+		return err
+	}
+
+	app.phantomCfg.Listeners[index].Schedule = expr
+	delete(app.scheduled, "forwarder:"+l.Listen)
+
+	if err := app.persistPhantomConfig(app.phantomCfg); err != nil {
+		return fmt.Errorf("failed to persist forwarder config: %w", err)
+	}
+
+	return nil
+}
+
+func (app *Application) UpdateTunnelSchedule(hostname string, expr string) error {
+	app.stateMu.Lock()
+	defer app.stateMu.Unlock()
+
+	if hostname == "" {
+		return fmt.Errorf("hostname cannot be empty")
+	}
+	if err := validSchedule(expr); err != nil {
+		return err
+	}
+
+	if i, ok := app.tunnelMeta(hostname); ok {
+		app.phantomCfg.TunnelMeta[i].Schedule = expr
+	} else {
+		app.phantomCfg.TunnelMeta = append(app.phantomCfg.TunnelMeta, TunnelMetadata{
+			Hostname: hostname,
+			Schedule: expr,
+		})
+	}
+	delete(app.scheduled, "tunnel:"+strings.ToLower(hostname))
+
+	if err := app.persistPhantomConfig(app.phantomCfg); err != nil {
+		return fmt.Errorf("failed to persist tunnel metadata: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package phantom
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"kon.nect.sh/phantom/internal/schedule"
+
+	"github.com/zhangyunhao116/skipmap"
+)
+
+// fire waits for the scheduler to ask for a timer, then moves the clock past it by
+// late. It returns the duration that was asked for.
+func fire(t *testing.T, clock *testClock, late time.Duration) time.Duration {
+	t.Helper()
+	d := clock.waitTimer(t)
+	clock.advance(d + late)
+	return d
+}
+
+type evaluation struct {
+	now     time.Time
+	active  bool
+	flipped bool
+}
+
+func startTestScheduler(t *testing.T, clock *testClock, expr string) (<-chan evaluation, <-chan time.Duration) {
+	s, err := schedule.Parse(expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &Application{scheduled: make(map[string]bool)}
+	evaluations := make(chan evaluation, 16)
+	gaps := make(chan time.Duration, 16)
+	sched := &scheduler{
+		clock: clock,
+		reconcile: func(now time.Time) {
+			active := s.Active(now)
+			evaluations <- evaluation{
+				now:     now,
+				active:  active,
+				flipped: app.scheduleFlipped("forwarder:test", active),
+			}
+		},
+		resumed: func(gap time.Duration) {
+			gaps <- gap
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sched.run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Error("scheduler did not stop")
+		}
+	})
+
+	return evaluations, gaps
+}
+
+func nextEvaluation(t *testing.T, evaluations <-chan evaluation) evaluation {
+	t.Helper()
+	select {
+	case e := <-evaluations:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("schedule was not evaluated")
+		return evaluation{}
+	}
+}
+
+func TestSchedulerWindowFlip(t *testing.T) {
+	start := time.Date(2024, time.January, 1, 8, 58, 30, 0, time.UTC)
+	clock := newTestClock(start)
+	evaluations, gaps := startTestScheduler(t, clock, "* 9-17 * * *")
+
+	expected := []struct {
+		wait    time.Duration
+		at      time.Time
+		active  bool
+		flipped bool
+	}{
+		{0, start, false, true}, // the first evaluation always counts as a change
+		{time.Second * 30, start.Add(time.Second * 30), false, false},
+		{time.Minute, start.Add(time.Second * 90), true, true},
+		{time.Minute, start.Add(time.Second * 150), true, false},
+	}
+
+	for i, exp := range expected {
+		if i > 0 {
+			if wait := fire(t, clock, 0); wait != exp.wait {
+				t.Fatalf("evaluation %d: expected to wait %s for the boundary, waited %s", i, exp.wait, wait)
+			}
+		}
+		e := nextEvaluation(t, evaluations)
+		if !e.now.Equal(exp.at) || e.active != exp.active || e.flipped != exp.flipped {
+			t.Fatalf("evaluation %d: expected active %t flipped %t at %s, got active %t flipped %t at %s",
+				i, exp.active, exp.flipped, exp.at, e.active, e.flipped, e.now)
+		}
+	}
+
+	select {
+	case gap := <-gaps:
+		t.Fatalf("unexpected resume after %s on regular ticks", gap)
+	default:
+	}
+}
+
+func TestSchedulerWakeGap(t *testing.T) {
+	start := time.Date(2024, time.January, 1, 8, 58, 30, 0, time.UTC)
+	clock := newTestClock(start)
+	evaluations, gaps := startTestScheduler(t, clock, "* 9-17 * * *")
+
+	if e := nextEvaluation(t, evaluations); e.active {
+		t.Fatal("expected the window to be closed at start")
+	}
+
+	// the machine sleeps through the boundary and wakes up within the window
+	fire(t, clock, time.Hour*3)
+
+	select {
+	case gap := <-gaps:
+		if expected := time.Hour*3 + time.Second*30; gap != expected {
+			t.Fatalf("expected a gap of %s, got %s", expected, gap)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the scheduler to report the gap")
+	}
+
+	e := nextEvaluation(t, evaluations)
+	if !e.active || !e.flipped {
+		t.Fatalf("expected the window to be applied after waking up, got active %t flipped %t", e.active, e.flipped)
+	}
+
+	// the next boundary is a regular tick again
+	if wait := fire(t, clock, 0); wait != time.Minute {
+		t.Fatalf("expected to wait a minute, waited %s", wait)
+	}
+	if e := nextEvaluation(t, evaluations); e.flipped {
+		t.Fatal("unexpected flip on the tick after waking up")
+	}
+	select {
+	case gap := <-gaps:
+		t.Fatalf("unexpected resume after %s", gap)
+	default:
+	}
+}
+
+func TestScheduleLaunchState(t *testing.T) {
+	now := time.Date(2024, time.January, 1, 10, 0, 0, 0, time.UTC)
+	app := &Application{
+		phantomCfg: &PhantomConfig{
+			ListenOnStart: true,
+			Listeners: []Listener{
+				{Listen: "127.0.0.1:8080", Schedule: "* 9-17 * * *"},
+				{Listen: "127.0.0.1:8081", Schedule: "* 0-8 * * *"},
+				{Listen: "127.0.0.1:8082"},
+			},
+		},
+		forwarders: skipmap.NewString[*forwarder](),
+		scheduled:  make(map[string]bool),
+	}
+
+	// forwarders outside their window are left for the scheduler
+	toStart := app.autostartListeners(now)
+	if len(toStart) != 2 || toStart[0].Listen != "127.0.0.1:8080" || toStart[1].Listen != "127.0.0.1:8082" {
+		t.Fatalf("expected only the forwarders within their window to be started at launch, got %v", toStart)
+	}
+
+	// the first evaluation after launch keeps a forwarder the user had stopped within its window stopped
+	app.seedSchedules(now)
+	if changes := app.reconcileForwarderSchedules(now); len(changes) != 0 {
+		t.Fatalf("expected no changes on the first evaluation, got %v", changes)
+	}
+	if _, running := app.forwarders.Load("127.0.0.1:8080"); running {
+		t.Fatal("expected the stopped forwarder to stay stopped")
+	}
+}
@@ -0,0 +1,288 @@
+package phantom
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	ListenerModeForward = ""
+	ListenerModeSNI     = "sni"
+	ListenerModeHTTP    = "http"
+)
+
+// connHandler serves the local connections of a forwarder in place of connector.HandleConnections
+type connHandler interface {
+	serve(listener net.Listener)
+	close()
+}
+
+const (
+	sniPeekTimeout = time.Second * 10
+	// maxDynamicRoutes bounds the wildcard routes of a forwarder, since any client can pick the server name
+	maxDynamicRoutes = 64
+)
+
+var errHelloPeeked = errors.New("client hello peeked")
+
+func isWildcardHostname(hostname string) bool {
+	return strings.HasPrefix(hostname, "*.")
+}
+
+// splitHostname separates the optional gateway port from a tunnel hostname
+func splitHostname(hostname string) (host, port string) {
+	if h, p, err := net.SplitHostPort(hostname); err == nil {
+		return strings.ToLower(h), p
+	}
+	return strings.ToLower(hostname), ""
+}
+
+type wildcardRoute struct {
+	suffix string // including the leading dot
+	port   string
+}
+
+// dynamicRouteFunc creates the lazy route of a hostname matched by a wildcard. The route
+// lives until ctx is done, and onReap is called once its idle gateway is disconnected.
+type dynamicRouteFunc func(ctx context.Context, hostname string, onReap func()) *route
+
+type dynamicRoute struct {
+	*route
+	cancel context.CancelFunc
+}
+
+// sniRouter reads the server name of incoming TLS connections and forwards them
+// untouched to the tunnel of the same hostname. Hostnames matched by a wildcard
+// get a lazy route on first use, which is dropped again once it goes idle.
+type sniRouter struct {
+	ctx       context.Context
+	logger    *zap.Logger
+	newRoute  dynamicRouteFunc
+	exact     map[string]*route
+	wildcards []wildcardRoute
+
+	mu      sync.Mutex
+	dynamic map[string]*dynamicRoute
+}
+
+var _ connHandler = (*sniRouter)(nil)
+
+func newSNIRouter(ctx context.Context, logger *zap.Logger, l Listener, routes []*route, newRoute dynamicRouteFunc) *sniRouter {
+	r := &sniRouter{
+		ctx:      ctx,
+		logger:   logger,
+		newRoute: newRoute,
+		exact:    make(map[string]*route),
+		dynamic:  make(map[string]*dynamicRoute),
+	}
+	for _, rt := range routes {
+		host, _ := splitHostname(rt.hostname)
+		r.exact[host] = rt
+	}
+	for _, h := range l.hostnames() {
+		if !isWildcardHostname(h) {
+			continue
+		}
+		host, port := splitHostname(h)
+		r.wildcards = append(r.wildcards, wildcardRoute{
+			suffix: strings.TrimPrefix(host, "*"),
+			port:   port,
+		})
+	}
+	return r
+}
+
+func (r *sniRouter) match(serverName string) (*route, bool) {
+	name := strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if rt, ok := r.exact[name]; ok {
+		return rt, true
+	}
+
+	for _, w := range r.wildcards {
+		label := strings.TrimSuffix(name, w.suffix)
+		if label == name || label == "" || strings.Contains(label, ".") {
+			continue
+		}
+
+		hostname := name
+		if w.port != "" {
+			hostname = net.JoinHostPort(name, w.port)
+		}
+
+		return r.dynamicRoute(hostname)
+	}
+
+	return nil, false
+}
+
+func (r *sniRouter) dynamicRoute(hostname string) (*route, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if d, ok := r.dynamic[hostname]; ok {
+		return d.route, true
+	}
+	if len(r.dynamic) >= maxDynamicRoutes && r.evictIdle() == 0 {
+		r.logger.Warn("Too many wildcard routes in use", zap.String("hostname", hostname), zap.Int("max", maxDynamicRoutes))
+		return nil, false
+	}
+
+	ctx, cancel := context.WithCancel(r.ctx)
+	d := &dynamicRoute{cancel: cancel}
+	d.route = r.newRoute(ctx, hostname, func() {
+		r.evict(hostname, d)
+	})
+	r.dynamic[hostname] = d
+	return d.route, true
+}
+
+// evict drops the route of hostname once its gateway was reaped, unless it was replaced already
+func (r *sniRouter) evict(hostname string, d *dynamicRoute) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.dynamic[hostname] != d {
+		return
+	}
+	delete(r.dynamic, hostname)
+	d.cancel()
+}
+
+// evictIdle drops the routes without a gateway connection and returns how many were dropped
+// r.mu must be held
+func (r *sniRouter) evictIdle() int {
+	evicted := 0
+	for hostname, d := range r.dynamic {
+		if d.dialer.connected() || d.dialer.active.Load() > 0 {
+			continue
+		}
+		delete(r.dynamic, hostname)
+		d.dialer.close()
+		d.cancel()
+		evicted++
+	}
+	return evicted
+}
+
+func (r *sniRouter) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			r.logger.Debug("Stopped accepting connections", zap.Error(err))
+			return
+		}
+		go r.handle(conn)
+	}
+}
+
+func (r *sniRouter) handle(conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(sniPeekTimeout))
+	serverName, hello, err := peekServerName(conn)
+	if err != nil {
+		r.logger.Debug("Failed to read server name", zap.String("remote", conn.RemoteAddr().String()), zap.Error(err))
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	rt, ok := r.match(serverName)
+	if !ok {
+		r.logger.Debug("No route for server name", zap.String("serverName", serverName))
+		return
+	}
+
+	remote, err := rt.dial(r.logger)
+	if err != nil {
+		return
+	}
+	defer remote.Close()
+
+	pipe(&peekedConn{Conn: conn, r: hello}, remote)
+}
+
+func (r *sniRouter) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for hostname, d := range r.dynamic {
+		delete(r.dynamic, hostname)
+		d.dialer.close()
+		d.cancel()
+	}
+}
+
+// peekServerName reads the ClientHello of a TLS connection, and returns a reader
+// that replays it followed by the rest of the connection
+func peekServerName(conn net.Conn) (string, io.Reader, error) {
+	var (
+		buf        bytes.Buffer
+		serverName string
+	)
+	err := tls.Server(&peekedConn{Conn: conn, r: io.TeeReader(conn, &buf), readOnly: true}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			return nil, errHelloPeeked
+		},
+	}).Handshake()
+	if serverName == "" {
+		if err != nil && !errors.Is(err, errHelloPeeked) {
+			return "", nil, err
+		}
+		return "", nil, fmt.Errorf("client did not send a server name")
+	}
+	return serverName, io.MultiReader(&buf, conn), nil
+}
+
+type peekedConn struct {
+	net.Conn
+	r        io.Reader
+	readOnly bool
+}
+
+var _ net.Conn = (*peekedConn)(nil)
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *peekedConn) Write(p []byte) (int, error) {
+	if c.readOnly {
+		return 0, io.ErrClosedPipe
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *peekedConn) Close() error {
+	if c.readOnly {
+		return nil
+	}
+	return c.Conn.Close()
+}
+
+// pipe copies between both connections until either side is done
+func pipe(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(b, a)
+		done <- struct{}{}
+	}()
+	<-done
+	a.Close()
+	b.Close()
+	<-done
+}
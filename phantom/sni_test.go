@@ -0,0 +1,87 @@
+package phantom
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func newTestSNIRouter(t *testing.T) (*sniRouter, map[string]func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	reapers := make(map[string]func())
+	r := newSNIRouter(ctx, zap.NewNop(), Listener{Hostname: "*.example.com"}, nil, func(ctx context.Context, hostname string, onReap func()) *route {
+		reapers[hostname] = onReap
+		dial := func(ctx context.Context) (gatewayConn, error) {
+			return gatewayConn{}, fmt.Errorf("not dialing in tests")
+		}
+		return newRoute(hostname, newLazyDialer(ctx, dial, time.Hour, onReap))
+	})
+	t.Cleanup(r.close)
+	return r, reapers
+}
+
+func TestSNIRouterDynamicRoutes(t *testing.T) {
+	r, reapers := newTestSNIRouter(t)
+
+	first, ok := r.match("one.example.com")
+	if !ok {
+		t.Fatal("expected the wildcard to match")
+	}
+	if again, _ := r.match("ONE.example.com."); again != first {
+		t.Fatal("expected the same hostname to reuse its route")
+	}
+	if _, ok := r.match("a.b.example.com"); ok {
+		t.Fatal("expected the wildcard to match a single label only")
+	}
+
+	reap := reapers["one.example.com"]
+	reap()
+	if len(r.dynamic) != 0 {
+		t.Fatalf("expected the reaped route to be evicted, got %d routes", len(r.dynamic))
+	}
+	second, _ := r.match("one.example.com")
+	if second == first {
+		t.Fatal("expected a new route after eviction")
+	}
+
+	// a late callback of the evicted route must leave its replacement alone
+	reap()
+	if again, _ := r.match("one.example.com"); again != second {
+		t.Fatal("expected the replacement route to survive the stale callback")
+	}
+}
+
+func TestSNIRouterDynamicRoutesBounded(t *testing.T) {
+	r, _ := newTestSNIRouter(t)
+
+	for i := 0; i < maxDynamicRoutes; i++ {
+		if _, ok := r.match(fmt.Sprintf("host%d.example.com", i)); !ok {
+			t.Fatalf("expected route %d to be created", i)
+		}
+	}
+	if len(r.dynamic) != maxDynamicRoutes {
+		t.Fatalf("expected %d routes, got %d", maxDynamicRoutes, len(r.dynamic))
+	}
+
+	// every route is idle, so they make room for the new one
+	if _, ok := r.match("extra.example.com"); !ok {
+		t.Fatal("expected idle routes to be evicted for a new hostname")
+	}
+	if len(r.dynamic) != 1 {
+		t.Fatalf("expected only the new route to remain, got %d", len(r.dynamic))
+	}
+
+	for i := 1; i < maxDynamicRoutes; i++ {
+		d, _ := r.match(fmt.Sprintf("busy%d.example.com", i))
+		d.dialer.active.Add(1)
+	}
+	r.dynamic["extra.example.com"].dialer.active.Add(1)
+	if _, ok := r.match("refused.example.com"); ok {
+		t.Fatal("expected a new hostname to be refused while every route is in use")
+	}
+}
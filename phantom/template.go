@@ -0,0 +1,240 @@
+package phantom
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"go.uber.org/zap"
+)
+
+const (
+	TemplateCleanupRemove = "remove"
+	TemplateCleanupBlank  = "blank"
+)
+
+const (
+	templateBlockBegin = "# BEGIN phantom managed block, do not edit"
+	templateBlockEnd   = "# END phantom managed block"
+)
+
+// TemplateConfig renders the addresses of the forwarders in a group into a file,
+// such as DATABASE_URL=postgres://{{.Listen}}/app into a .env file. The output is
+// kept in a managed block, so the rest of an existing file is left untouched. The
+// block is delimited by # comments, as used by .env, YAML and TOML files.
+type TemplateConfig struct {
+	Path     string `json:"path"`
+	Template string `json:"template"`
+	Cleanup  string `json:"cleanup,omitempty"` // once the group stops, remove the block and the file if nothing else is left, or blank to keep it; defaults to remove
+}
+
+type TemplateForwarder struct {
+	Label    string
+	Listen   string // bound address
+	Host     string
+	Port     string
+	Hostname string
+	Running  bool
+}
+
+// TemplateData embeds the first running forwarder of the group, so single forwarder
+// groups can use {{.Listen}}, and others {{(index .Forwarders "label").Listen}}
+type TemplateData struct {
+	TemplateForwarder
+	Group      string
+	Forwarders map[string]TemplateForwarder
+}
+
+func hasTemplatePath(templates []TemplateConfig, path string) bool {
+	for _, t := range templates {
+		if t.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+func parseTemplate(t TemplateConfig) (*template.Template, error) {
+	if !filepath.IsAbs(t.Path) {
+		return nil, fmt.Errorf("template path %q must be absolute", t.Path)
+	}
+	switch t.Cleanup {
+	case "", TemplateCleanupRemove, TemplateCleanupBlank:
+	default:
+		return nil, fmt.Errorf("unknown template cleanup %q", t.Cleanup)
+	}
+	tmpl, err := template.New(filepath.Base(t.Path)).Option("missingkey=error").Parse(t.Template)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template for %s: %w", t.Path, err)
+	}
+	return tmpl, nil
+}
+
+// app.stateMu must be held
+func (app *Application) templateData(group string) (TemplateData, bool) {
+	data := TemplateData{
+		Group:      group,
+		Forwarders: make(map[string]TemplateForwarder),
+	}
+	running := false
+	for _, l := range app.groupListeners(group) {
+		tf := TemplateForwarder{
+			Label:    l.Label,
+			Hostname: l.Hostname,
+		}
+		if f, ok := app.forwarders.Load(l.Listen); ok {
+			tf.Listen = f.bound
+			tf.Host, tf.Port, _ = net.SplitHostPort(f.bound)
+			tf.Running = true
+			if !running {
+				data.TemplateForwarder = tf
+				running = true
+			}
+		}
+		key := l.Label
+		if key == "" {
+			key = l.Listen
+		}
+		data.Forwarders[key] = tf
+	}
+	return data, running
+}
+
+// renderTemplates writes the templates of group while any of its forwarders run, and cleans them up otherwise
+func (app *Application) renderTemplates(group string) {
+	if group == "" {
+		return
+	}
+
+	app.templatesMu.Lock()
+	defer app.templatesMu.Unlock()
+
+	app.stateMu.RLock()
+	g, _ := app.groupConfig(group)
+	data, running := app.templateData(group)
+	app.stateMu.RUnlock()
+
+	for _, t := range g.Templates {
+		var err error
+		if running {
+			err = renderTemplate(t, data)
+		} else {
+			err = cleanupTemplate(t)
+		}
+		if err != nil {
+			app.logger.Error("Failed to render template", zap.String("group", group), zap.String("path", t.Path), zap.Error(err))
+			runtime.EventsEmit(app.appCtx, "template:Error", group, t.Path, err.Error())
+		}
+	}
+}
+
+// cleanupAllTemplates removes or blanks the output of every template, for shutdown
+func (app *Application) cleanupAllTemplates() {
+	app.templatesMu.Lock()
+	defer app.templatesMu.Unlock()
+
+	app.stateMu.RLock()
+	groups := app.phantomCfg.Groups
+	app.stateMu.RUnlock()
+
+	for _, g := range groups {
+		for _, t := range g.Templates {
+			if err := cleanupTemplate(t); err != nil {
+				app.logger.Error("Failed to clean up template", zap.String("group", g.Name), zap.String("path", t.Path), zap.Error(err))
+			}
+		}
+	}
+}
+
+func renderTemplate(t TemplateConfig, data TemplateData) error {
+	tmpl, err := parseTemplate(t)
+	if err != nil {
+		return err
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return err
+	}
+
+	existing, err := os.ReadFile(t.Path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	out := withTemplateBlock(stripTemplateBlock(string(existing)), sb.String(), lineEnding(existing))
+	if err == nil && string(existing) == out {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(t.Path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(t.Path, []byte(out), 0600)
+}
+
+// cleanupTemplate removes the managed block, and only what was written by Phantom
+func cleanupTemplate(t TemplateConfig) error {
+	existing, err := os.ReadFile(t.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	rest := stripTemplateBlock(string(existing))
+	if rest == string(existing) {
+		return nil
+	}
+	if strings.TrimSpace(rest) == "" && t.Cleanup != TemplateCleanupBlank {
+		return os.Remove(t.Path)
+	}
+	return os.WriteFile(t.Path, []byte(rest), 0600)
+}
+
+func lineEnding(content []byte) string {
+	if bytes.Contains(content, []byte("\r\n")) {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// stripTemplateBlock returns the content without the managed block. An unterminated block extends to the end of the file.
+func stripTemplateBlock(content string) string {
+	var (
+		sb      strings.Builder
+		inBlock bool
+	)
+	for _, line := range strings.SplitAfter(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == templateBlockBegin:
+			inBlock = true
+		case inBlock && trimmed == templateBlockEnd:
+			inBlock = false
+		case !inBlock:
+			sb.WriteString(line)
+		}
+	}
+	return sb.String()
+}
+
+func withTemplateBlock(content, rendered, eol string) string {
+	var sb strings.Builder
+	sb.WriteString(content)
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		sb.WriteString(eol)
+	}
+	sb.WriteString(templateBlockBegin + eol)
+	sb.WriteString(rendered)
+	if rendered != "" && !strings.HasSuffix(rendered, "\n") {
+		sb.WriteString(eol)
+	}
+	sb.WriteString(templateBlockEnd + eol)
+	return sb.String()
+}
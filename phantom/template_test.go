@@ -0,0 +1,103 @@
+package phantom
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readTemplateOutput(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestTemplateExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	original := "SECRET=hunter2\nDEBUG=1\n"
+	if err := os.WriteFile(path, []byte(original), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tc := TemplateConfig{Path: path, Template: "DATABASE_URL=postgres://{{.Listen}}/app"}
+	data := TemplateData{TemplateForwarder: TemplateForwarder{Listen: "127.0.0.1:5432"}}
+	if err := renderTemplate(tc, data); err != nil {
+		t.Fatal(err)
+	}
+	expected := original + templateBlockBegin + "\nDATABASE_URL=postgres://127.0.0.1:5432/app\n" + templateBlockEnd + "\n"
+	if got := readTemplateOutput(t, path); got != expected {
+		t.Fatalf("expected the block to be appended, got %q", got)
+	}
+
+	// rendering again replaces the block in place
+	data.Listen = "127.0.0.1:5433"
+	if err := renderTemplate(tc, data); err != nil {
+		t.Fatal(err)
+	}
+	expected = original + templateBlockBegin + "\nDATABASE_URL=postgres://127.0.0.1:5433/app\n" + templateBlockEnd + "\n"
+	if got := readTemplateOutput(t, path); got != expected {
+		t.Fatalf("expected the block to be replaced, got %q", got)
+	}
+
+	if err := cleanupTemplate(tc); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTemplateOutput(t, path); got != original {
+		t.Fatalf("expected the original content to be restored, got %q", got)
+	}
+
+	// a file without a block is not touched
+	if err := cleanupTemplate(tc); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTemplateOutput(t, path); got != original {
+		t.Fatalf("expected the file to be left alone, got %q", got)
+	}
+}
+
+func TestTemplateCreatedFile(t *testing.T) {
+	data := TemplateData{TemplateForwarder: TemplateForwarder{Listen: "127.0.0.1:5432"}}
+
+	cases := []struct {
+		name    string
+		cleanup string
+		kept    bool
+	}{
+		{name: "remove", cleanup: TemplateCleanupRemove},
+		{name: "default"},
+		{name: "blank", cleanup: TemplateCleanupBlank, kept: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := TemplateConfig{
+				Path:     filepath.Join(t.TempDir(), "config", "app.env"),
+				Template: "ADDR={{.Listen}}\n",
+				Cleanup:  tc.cleanup,
+			}
+			if err := renderTemplate(cfg, data); err != nil {
+				t.Fatal(err)
+			}
+			if err := cleanupTemplate(cfg); err != nil {
+				t.Fatal(err)
+			}
+
+			content, err := os.ReadFile(cfg.Path)
+			if !tc.kept {
+				if !errors.Is(err, os.ErrNotExist) {
+					t.Fatalf("expected the file to be removed, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(content) != 0 {
+				t.Fatalf("expected the file to be blank, got %q", content)
+			}
+		})
+	}
+}
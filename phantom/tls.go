@@ -0,0 +1,78 @@
+package phantom
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"kon.nect.sh/phantom/internal/devca"
+)
+
+// tlsNames returns the names the local certificate of a forwarder is issued for
+func (l *Listener) tlsNames() []string {
+	if len(l.TLSNames) > 0 {
+		return l.TLSNames
+	}
+	names := []string{"localhost", "127.0.0.1", "::1"}
+	if host, _, err := net.SplitHostPort(l.Listen); err == nil && host != "" {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsUnspecified() {
+			names = append(names, host)
+		}
+	}
+
+	seen := make(map[string]bool)
+	unique := make([]string, 0, len(names))
+	for _, n := range names {
+		key := strings.ToLower(n)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, n)
+	}
+	return unique
+}
+
+// authority loads the development CA on first use, generating it if needed
+func (app *Application) authority() (*devca.Authority, error) {
+	app.caMu.Lock()
+	defer app.caMu.Unlock()
+
+	if app.ca != nil {
+		return app.ca, nil
+	}
+	ca, err := devca.Load(filepath.Join(configPath, "ca"))
+	if err != nil {
+		return nil, err
+	}
+	app.ca = ca
+	return ca, nil
+}
+
+func (app *Application) terminateTLS(l Listener, listener net.Listener) (net.Listener, error) {
+	ca, err := app.authority()
+	if err != nil {
+		return nil, fmt.Errorf("error loading development CA: %w", err)
+	}
+	return tls.NewListener(listener, ca.ServerConfig(l.tlsNames())), nil
+}
+
+// ExportDevCA returns the PEM encoded development CA certificate, for installing into a trust store
+func (app *Application) ExportDevCA() (string, error) {
+	ca, err := app.authority()
+	if err != nil {
+		return "", err
+	}
+	return string(ca.CertPEM()), nil
+}
+
+// GetDevCAPath returns the path of the development CA certificate on disk
+func (app *Application) GetDevCAPath() (string, error) {
+	ca, err := app.authority()
+	if err != nil {
+		return "", err
+	}
+	return ca.CertPath(), nil
+}
@@ -0,0 +1,54 @@
+package phantom
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestForwarderInvalidDevCA(t *testing.T) {
+	prev := configPath
+	configPath = t.TempDir()
+	t.Cleanup(func() { configPath = prev })
+
+	caDir := filepath.Join(configPath, "ca")
+	if err := os.MkdirAll(caDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(caDir, "ca.pem"), []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen := probe.Addr().String()
+	probe.Close()
+
+	app := &Application{appCtx: context.Background()}
+	l := Listener{
+		Listen:       listen,
+		Hostname:     "app.example.com",
+		TerminateTLS: true,
+	}
+	acl, err := newAccessList(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := app.getNewForwarder(zap.NewNop(), l, acl); err == nil {
+		t.Fatal("expected the forwarder to fail with an invalid development CA")
+	}
+
+	// the local listener must be released
+	again, err := net.Listen("tcp", listen)
+	if err != nil {
+		t.Fatalf("expected %s to be free again: %v", listen, err)
+	}
+	again.Close()
+}
@@ -0,0 +1,232 @@
+package phantom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"kon.nect.sh/specter/tun/client/dialer"
+
+	"go.uber.org/zap"
+)
+
+const (
+	TransportQUIC = "quic"
+	TransportTCP  = "tcp"
+	TransportAuto = "auto"
+)
+
+const quicAttemptTimeout = time.Second * 5
+
+func (l *Listener) transport() string {
+	switch strings.ToLower(l.Transport) {
+	case TransportQUIC:
+		return TransportQUIC
+	case TransportTCP:
+		return TransportTCP
+	case TransportAuto:
+		return TransportAuto
+	}
+	if l.UseTCP {
+		return TransportTCP
+	}
+	return TransportQUIC
+}
+
+type gatewayConn struct {
+	remote    net.Addr
+	dialer    dialer.TransportDialer
+	transport string
+}
+
+// transportMemoTTL is how long auto mode sticks to TLS on a network before trying QUIC again
+const transportMemoTTL = time.Minute * 30
+
+// networkKeyTimeout bounds resolving the gateway while identifying the network
+const networkKeyTimeout = time.Second * 2
+
+type memoEntry struct {
+	transport string
+	at        time.Time
+}
+
+// transportMemo remembers which transport worked for a gateway on a given
+// network, so auto mode does not have to wait for QUIC to time out every time.
+type transportMemo struct {
+	mu     sync.RWMutex
+	chosen map[string]memoEntry
+}
+
+func newTransportMemo() *transportMemo {
+	return &transportMemo{
+		chosen: make(map[string]memoEntry),
+	}
+}
+
+// networkKey identifies the current network by the local address used to reach
+// the gateway. Dialing UDP does not send any packet.
+func networkKey(host string) string {
+	d := net.Dialer{Timeout: networkKeyTimeout}
+	conn, err := d.Dial("udp", net.JoinHostPort(host, "443"))
+	if err != nil {
+		return host
+	}
+	defer conn.Close()
+
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		return addr.IP.String() + "/" + host
+	}
+	return host
+}
+
+func memoHost(key, host string) bool {
+	return key == host || strings.HasSuffix(key, "/"+host)
+}
+
+// get returns the transport to use on the network, a TLS fallback is only
+// remembered for transportMemoTTL so QUIC gets retried periodically
+func (m *transportMemo) get(key string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	e, ok := m.chosen[key]
+	if !ok || (e.transport == TransportTCP && time.Since(e.at) > transportMemoTTL) {
+		return ""
+	}
+	return e.transport
+}
+
+func (m *transportMemo) set(key, transport string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.chosen[key] = memoEntry{
+		transport: transport,
+		at:        time.Now(),
+	}
+}
+
+// quicBlocked reports whether auto mode recently had to fall back to TLS for host
+func (m *transportMemo) quicBlocked(host string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for key, e := range m.chosen {
+		if memoHost(key, host) && e.transport == TransportTCP && time.Since(e.at) <= transportMemoTTL {
+			return true
+		}
+	}
+	return false
+}
+
+// forget drops the fallbacks remembered for host, such as once QUIC to it worked again
+func (m *transportMemo) forget(host string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key := range m.chosen {
+		if memoHost(key, host) {
+			delete(m.chosen, key)
+		}
+	}
+}
+
+type gatewayOptions struct {
+	memo  *transportMemo
+	proxy *proxyDialer
+}
+
+func dialTransport(ctx context.Context, transport string, cfg dialer.DialerConfig, proxy *proxyDialer) (gatewayConn, error) {
+	if transport == TransportTCP && proxy != nil {
+		d, err := newProxiedTLSDialer(ctx, proxy, cfg)
+		if err != nil {
+			return gatewayConn{}, err
+		}
+		return gatewayConn{
+			remote:    d.Remote(),
+			dialer:    d,
+			transport: transport,
+		}, nil
+	}
+
+	var (
+		remote net.Addr
+		td     dialer.TransportDialer
+		err    error
+	)
+	if transport == TransportTCP {
+		remote, td, err = dialer.TLSDialer(ctx, cfg)
+	} else {
+		remote, td, err = dialer.QuicDialer(ctx, cfg)
+	}
+	if err != nil {
+		return gatewayConn{}, err
+	}
+	return gatewayConn{
+		remote:    remote,
+		dialer:    td,
+		transport: transport,
+	}, nil
+}
+
+// dialAuto tries QUIC first and falls back to TLS over TCP if QUIC cannot be
+// established in time, unless TLS is already known to be required on this network.
+func dialAuto(ctx context.Context, logger *zap.Logger, opts gatewayOptions, cfg dialer.DialerConfig) (gatewayConn, error) {
+	key := networkKey(cfg.Parsed.Host)
+
+	if opts.memo.get(key) == TransportTCP {
+		return dialTransport(ctx, TransportTCP, cfg, opts.proxy)
+	}
+
+	type result struct {
+		gw  gatewayConn
+		err error
+	}
+
+	// on success, quicCtx becomes the lifetime of the gateway connection
+	quicCtx, quicCancel := context.WithCancel(ctx)
+	established := false
+	defer func() {
+		if !established {
+			quicCancel()
+		}
+	}()
+
+	done := make(chan result, 1)
+	go func() {
+		gw, err := dialTransport(quicCtx, TransportQUIC, cfg, nil)
+		done <- result{gw, err}
+	}()
+
+	timer := time.NewTimer(quicAttemptTimeout)
+	defer timer.Stop()
+
+	var quicErr error
+	select {
+	case r := <-done:
+		if r.err == nil {
+			established = true
+			opts.memo.set(key, TransportQUIC)
+			return r.gw, nil
+		}
+		quicErr = r.err
+	case <-timer.C:
+		quicErr = fmt.Errorf("timed out after %s", quicAttemptTimeout)
+		quicCancel()
+	case <-ctx.Done():
+		return gatewayConn{}, ctx.Err()
+	}
+
+	logger.Info("QUIC transport unavailable, falling back to TLS", zap.String("network", key), zap.NamedError("quic", quicErr))
+
+	gw, err := dialTransport(ctx, TransportTCP, cfg, opts.proxy)
+	if err != nil {
+		return gatewayConn{}, errors.Join(fmt.Errorf("quic: %w", quicErr), fmt.Errorf("tls: %w", err))
+	}
+	opts.memo.set(key, TransportTCP)
+	return gw, nil
+}
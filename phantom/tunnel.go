@@ -4,6 +4,8 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"kon.nect.sh/specter/overlay"
@@ -15,6 +17,7 @@ import (
 	"kon.nect.sh/specter/tun/client/dialer"
 
 	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"go.uber.org/zap"
 )
 
 func (app *Application) Connected() bool {
@@ -39,7 +42,9 @@ func (app *Application) GetPhantomConfig() PhantomConfig {
 	app.stateMu.RLock()
 	defer app.stateMu.RUnlock()
 
-	return *app.phantomCfg
+	cfg := *app.phantomCfg
+	cfg.Proxy = cfg.Proxy.redacted()
+	return cfg
 }
 
 func (app *Application) RebuildTunnels(tunnels []client.Tunnel) {
@@ -64,8 +69,56 @@ func (app *Application) UnpublishTunnel(index int) error {
 		if index < 0 || index > len(cfg.Tunnels) {
 			return fmt.Errorf("tunnel index out of bound")
 		}
-		return app.cli.UnpublishTunnel(app.appCtx, cfg.Tunnels[index])
+		if err := app.cli.UnpublishTunnel(app.appCtx, cfg.Tunnels[index]); err != nil {
+			return err
+		}
+		delete(app.published, strings.ToLower(cfg.Tunnels[index].Hostname))
+		return nil
+	}
+}
+
+// PublishTunnel publishes a single tunnel, including one that was unpublished after
+// its TTL, which starts its TTL again
+func (app *Application) PublishTunnel(index int) error {
+	app.stateMu.Lock()
+	defer app.stateMu.Unlock()
+
+	if app.cli == nil {
+		return fmt.Errorf("specter client is not connected")
+	}
+
+	cfg := app.cli.GetCurrentConfig()
+	if index < 0 || index >= len(cfg.Tunnels) {
+		return fmt.Errorf("tunnel index out of bound")
+	}
+	key := strings.ToLower(cfg.Tunnels[index].Hostname)
+	delete(app.expired, key)
+
+	// tunnels that are not published stay that way
+	now := time.Now()
+	withheld := app.withheldTunnels(now)
+	for k := range app.unpublishedTunnels(app.cli) {
+		withheld[k] = true
+	}
+	delete(withheld, key)
+	app.logger.Info("Publishing tunnel", zap.String("hostname", cfg.Tunnels[index].Hostname))
+	return app.syncTunnels(app.cli, now, withheld, func() error {
+		app.cli.SyncConfigTunnels(app.cliCtx)
+		return nil
+	})
+}
+
+// GetExpiredTunnels returns the hostnames of the tunnels that were unpublished after their TTL
+func (app *Application) GetExpiredTunnels() []string {
+	app.stateMu.RLock()
+	defer app.stateMu.RUnlock()
+
+	hostnames := make([]string, 0, len(app.expired))
+	for key := range app.expired {
+		hostnames = append(hostnames, key)
 	}
+	sort.Strings(hostnames)
+	return hostnames
 }
 
 func (app *Application) ReleaseTunnel(index int) error {
@@ -83,7 +136,13 @@ func (app *Application) ReleaseTunnel(index int) error {
 		if index < 0 || index > len(cfg.Tunnels) {
 			return fmt.Errorf("tunnel index out of bound")
 		}
-		return app.cli.ReleaseTunnel(app.appCtx, cfg.Tunnels[index])
+		if err := app.cli.ReleaseTunnel(app.appCtx, cfg.Tunnels[index]); err != nil {
+			return err
+		}
+		key := strings.ToLower(cfg.Tunnels[index].Hostname)
+		delete(app.published, key)
+		delete(app.expired, key)
+		return nil
 	}
 }
 
@@ -95,7 +154,13 @@ func (app *Application) Synchronize() {
 		return
 	}
 
-	app.cli.SyncConfigTunnels(app.cliCtx)
+	now := time.Now()
+	app.syncTunnels(app.cli, now, app.withheldTunnels(now), func() error {
+		app.cli.SyncConfigTunnels(app.cliCtx)
+		return nil
+	})
+
+	app.queueMirrorSync()
 }
 
 func (app *Application) UpdateApex(apex string) {
@@ -145,6 +210,10 @@ func (app *Application) StartClient() (err error) {
 		},
 	}
 
+	if app.phantomCfg.Proxy.Mode != ProxyModeNone {
+		app.logger.Warn("Specter client connects over QUIC and does not use the configured proxy", zap.String("proxy", proxyDescription(app.phantomCfg.Proxy)))
+	}
+
 	app.transportRTT = rttImpl.NewInstrumentation(20)
 	app.transport = overlay.NewQUIC(overlay.TransportConfig{
 		Logger: app.logger,
@@ -170,11 +239,23 @@ func (app *Application) StartClient() (err error) {
 	}
 
 	if err = c.Register(app.cliCtx); err != nil {
+		// the specter client only has a QUIC transport, so there is nothing to fall back to
+		if app.transports.quicBlocked(parsed.Host) {
+			err = fmt.Errorf("%w (QUIC appears to be blocked on this network, and the specter client cannot fall back to TLS)", err)
+		} else if app.phantomCfg.Proxy.Mode != ProxyModeNone {
+			err = fmt.Errorf("%w (the specter client connects over QUIC directly and cannot use the configured proxy)", err)
+		}
 		runtime.LogError(app.appCtx, err.Error())
 		return
 	}
-
-	if err = c.Initialize(app.cliCtx); err != nil {
+	// QUIC works again, so auto forwarders should stop skipping it
+	app.transports.forget(parsed.Host)
+
+	// initializing publishes the configured tunnels, so the ones outside their schedule are withheld
+	now := time.Now()
+	if err = app.syncTunnels(c, now, app.withheldTunnels(now), func() error {
+		return c.Initialize(app.cliCtx)
+	}); err != nil {
 		runtime.LogError(app.appCtx, err.Error())
 		return
 	}
@@ -184,6 +265,8 @@ func (app *Application) StartClient() (err error) {
 	app.cli = c
 	runtime.EventsEmit(app.appCtx, "specter:Connected")
 
+	app.queueMirrorSync()
+
 	return
 }
 
@@ -204,6 +287,13 @@ func (app *Application) StopClient() {
 	app.transport.Stop()
 
 	app.cli = nil
+
+	// tunnels are published again on the next connect, so their schedules need to be re-applied
+	for key := range app.scheduled {
+		if strings.HasPrefix(key, "tunnel:") {
+			delete(app.scheduled, key)
+		}
+	}
 }
 
 type TunnelNode struct {